@@ -4,7 +4,11 @@
 package JX9
 
 import (
+	"bytes"
 	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
 
 	ugo "github.com/GJRTimmer/unqlitego"
 )
@@ -62,13 +66,31 @@ func (script *JX9_script) CreateOpenDataBase(database_name, pointer_name string)
 		1.error:If compilation error occured it will be returned the error string please check , unqlitego.errString.
 		If compilation suceeded than nil is returned.
 		2.string:If a compilation failed the error log will be returned, if compilation suceeded this should be ingnored
-		3.unqlitego.VM:The VM on which this code was compiled at (if compilation suceeded)
+		3.unqlitego.VM:The VM on which this code was compiled at (if compilation suceeded), it must be closed after use
+	The VM refers to a copy of the database, which must be kept open while the VM is used.
+	CompileVM compiles against the *unqlitego.Database itself.
 */
 func (script *JX9_script) Compile(database ugo.Database) (string, ugo.VM, error) {
+	out, vm, err := script.CompileVM(&database)
+	// The returned copy owns the VM, collecting the original must not release it
+	runtime.SetFinalizer(vm, nil)
+
+	return out, *vm, err
+}
+
+/*	Complie the jx9 script code against the database
+		databse:The databse which this code will compile aginst
+
+	The fuction will return the following
+		1.string:If a compilation failed the error log will be returned, if compilation suceeded this should be ingnored
+		2.*unqlitego.VM:The VM on which this code was compiled at (if compilation suceeded), it must be closed after use
+		3.error:If compilation error occured it will be returned
+*/
+func (script *JX9_script) CompileVM(database *ugo.Database) (string, *ugo.VM, error) {
 	vm := ugo.NewVM()
 	out, err := database.Compile(script.GetScript(), vm)
 
-	return out, *vm, err
+	return out, vm, err
 }
 
 /*	Complie the JX9 script code and execute it
@@ -78,7 +100,8 @@ func (script *JX9_script) Compile(database ugo.Database) (string, ugo.VM, error)
 		1,error:if error occured during coplialtion of the script return the error code
 		2.string:if error occured during copliation , return the error message
 		3.string:if copliation ended successfully the output of the script is returned
-		4.unqlitego.VM:The instance of the vm used to execute the script is returned
+		4.unqlitego.VM:The instance of the vm used to execute the script is returned, it must be closed after use
+	CompileAndExecuteVM executes against the *unqlitego.Database itself.
 */
 func (script JX9_script) CompileAndExecute(database ugo.Database) (string, string, ugo.VM, error) {
 	out, vm, err := script.Compile(database)
@@ -86,6 +109,57 @@ func (script JX9_script) CompileAndExecute(database ugo.Database) (string, strin
 	return out, vm.Result(), vm, err
 }
 
+/*	Complie the JX9 script code against the database and execute it
+		databse:The databse which this code will compile aginst
+
+	The function will return the following
+		1.string:if error occured during coplialtion of the script, return the error message
+		2.string:if copliation ended successfully the output of the script is returned
+		3.*unqlitego.VM:The instance of the vm used to execute the script is returned, it must be closed after use
+		4.error:if error occured during coplialtion of the script return the error code
+*/
+func (script JX9_script) CompileAndExecuteVM(database *ugo.Database) (string, string, *ugo.VM, error) {
+	out, vm, err := script.CompileVM(database)
+	vm.Execute()
+	return out, vm.Result(), vm, err
+}
+
+//Operand addresses in a bytecode dump
+var dumpAddress = regexp.MustCompile(`0x[0-9a-f]+`)
+
+/*	Compile the JX9 script and return the disassembly of its bytecode
+		database:The database struct which this code will compile against
+
+	The function will return the following
+		1.string:The disassembly. Operand addresses change from run to run, they are replaced by labels
+		(@1, @2...) numbered in order of appearance so dumps of script versions can be compared.
+		2.error:If compilation failed the error is returned
+*/
+func (script *JX9_script) Disassemble(database *ugo.Database) (string, error) {
+	_, vm, err := script.CompileVM(database)
+	if err != nil {
+		return "", err
+	}
+	defer vm.Close()
+
+	var buf bytes.Buffer
+	if err := vm.Dump(&buf); err != nil {
+		return "", err
+	}
+
+	labels := make(map[string]string)
+	dump := dumpAddress.ReplaceAllStringFunc(buf.String(), func(addr string) string {
+		label, ok := labels[addr]
+		if !ok {
+			label = "@" + strconv.Itoa(len(labels)+1)
+			labels[addr] = label
+		}
+		return fmt.Sprintf("%*s", len(addr), label)
+	})
+
+	return dump, nil
+}
+
 /*	This will add a JX9 snippet script to the current script which stores a JSON/JSON list to a database.
 	database_name:The name of the database.
 	json_code:The JSON/JSON list to be added to the databse.
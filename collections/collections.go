@@ -74,6 +74,9 @@ func (collection *UnqliteCollection) GetLastExecutionOutput() string {
 
 func (collection *UnqliteCollection) Flush() {
 	collection._commited = false
+	if collection._last_vm != nil {
+		collection._last_vm.Close()
+	}
 	collection._last_vm = nil
 	collection._last_error = ""
 	collection._last_output = ""
@@ -87,9 +90,9 @@ func (collection *UnqliteCollection) Commit() error {
 	if collection._commited {
 		return UnqliteCollectionError("This collection data is marked as commited")
 	}
-	res, out, vm, err := collection.script.CompileAndExecute(*collection.database)
+	res, out, vm, err := collection.script.CompileAndExecuteVM(collection.database)
 	collection._commited = true
-	collection._last_vm = &vm
+	collection._last_vm = vm
 	collection._last_output = out
 	collection._last_error = res
 	if err != nil {
@@ -172,6 +175,20 @@ func (collection *UnqliteCollection) GetAll() (error, string) {
 	return nil, ""
 }
 
+/*	Decode all the records of the collection into dst, which can point to a slice of
+	map[string]interface{} or of structs with json tags.
+*/
+func (collection *UnqliteCollection) GetAllInto(dst interface{}) error {
+	if collection._commited {
+		collection.Flush()
+	}
+	collection.script.GetAllFromDatatBase(collection.name, "all_obj")
+	if err := collection.Commit(); err != nil {
+		return err
+	}
+	return collection._last_vm.ExtractInto("all_obj", dst)
+}
+
 func (collection *UnqliteCollection) GetTotalNumberOfRecord() (error, int64) {
 	{
 		var res error
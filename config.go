@@ -0,0 +1,193 @@
+package unqlitego
+
+// #include <unqlite.h>
+// #include <wrappers.h>
+// #include <stdlib.h>
+import "C"
+
+import (
+	"strings"
+	"unsafe"
+)
+
+// minPageCache is the lowest page cache limit accepted by the pager.
+const minPageCache = 256
+
+// Config represents the database configuration applied through unqlite_config.
+// Zero values leave the engine defaults untouched.
+type Config struct {
+	// MaxPageCache is the maximum number of pages to keep in the cache.
+	// This is a simple hint the pager is not forced to honor, must be at least 256.
+	MaxPageCache int
+
+	// KVEngine is the name of the Key/Value storage engine to use (i.e: hash, mem),
+	// or of a Go engine installed with Library.RegisterKVEngine.
+	KVEngine string
+
+	// DisableAutoCommit disables the automatic commit of the open transaction
+	// when the database is closed.
+	DisableAutoCommit bool
+}
+
+// validate checks the Config before it is handed to the engine.
+func (c Config) validate() error {
+	if c.MaxPageCache != 0 && c.MaxPageCache < minPageCache {
+		return ErrInvalidConfig
+	}
+
+	return nil
+}
+
+// WithConfig applies the Config right after the database is opened.
+func WithConfig(c Config) Option {
+	return func(o *options) {
+		o.config = &c
+	}
+}
+
+// Config applies the Config to the database.
+func (db *Database) Config(c Config) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	if c.MaxPageCache != 0 {
+		if err := db.SetMaxPageCache(c.MaxPageCache); err != nil {
+			return err
+		}
+	}
+
+	if c.KVEngine != "" {
+		if err := db.SetKVEngine(c.KVEngine); err != nil {
+			return err
+		}
+	}
+
+	if c.DisableAutoCommit {
+		if err := db.DisableAutoCommit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetMaxPageCache sets the maximum number of pages to keep in the cache.
+func (db *Database) SetMaxPageCache(n int) error {
+	if n < minPageCache {
+		return ErrInvalidConfig
+	}
+
+	res := C.config_max_page_cache(db.conn, C.int(n))
+	if res != C.UNQLITE_OK {
+		return db.error("SetMaxPageCache", nil, res)
+	}
+
+	return nil
+}
+
+// SetKVEngine selects the Key/Value storage engine by name, before any record is stored.
+// A new database file records the engine and selects it again when reopened.
+// An unknown engine returns ErrNotImplemented. ErrLocked is returned while the
+// database holds records or cursors are open, the current engine is kept then.
+// The hash engine stores its records in the database file, ErrInvalid is returned
+// for an in-memory database.
+// A database file which already holds records keeps the engine it was created with.
+func (db *Database) SetKVEngine(name string) error {
+	if name == "" {
+		return ErrInvalidConfig
+	}
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	res := C.config_kv_engine(db.conn, cname)
+	if res != C.UNQLITE_OK {
+		return db.error("SetKVEngine", nil, res)
+	}
+
+	return nil
+}
+
+// DisableAutoCommit disables the automatic commit of the open transaction
+// when the database is closed. Any uncommitted changes are rolled back instead.
+func (db *Database) DisableAutoCommit() error {
+	res := C.config_disable_auto_commit(db.conn)
+	if res != C.UNQLITE_OK {
+		return db.error("DisableAutoCommit", nil, res)
+	}
+
+	return nil
+}
+
+// KVEngine returns the name of the underlying Key/Value storage engine.
+// The engine of an existing database file is loaded from its header on first access.
+func (db *Database) KVEngine() (string, error) {
+	var name *C.char
+
+	res := C.config_get_kv_name(db.conn, &name)
+	if res != C.UNQLITE_OK {
+		return "", db.error("KVEngine", nil, res)
+	}
+
+	return C.GoString(name), nil
+}
+
+// ErrLog returns the contents of the database error log.
+// Entries already reported by an Error are discarded from the log.
+func (db *Database) ErrLog() (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.conn == nil {
+		return "", newError("ErrLog", nil, C.UNQLITE_CORRUPT)
+	}
+
+	var buf *C.char
+	var n C.int
+
+	res := C.config_err_log(db.conn, &buf, &n)
+	if res != C.UNQLITE_OK {
+		return "", newError("ErrLog", nil, res)
+	}
+
+	return C.GoStringN(buf, n), nil
+}
+
+// jx9ErrLog returns the JX9 compile-time error log.
+func (db *Database) jx9ErrLog() string {
+	var buf *C.char
+	var n C.int
+
+	res := C.config_jx9_err_log(db.conn, &buf, &n)
+	if res != C.UNQLITE_OK || buf == nil {
+		return ""
+	}
+
+	return strings.TrimSpace(C.GoStringN(buf, n))
+}
+
+// newErrLog returns the error log written since the last call, and discards
+// it so the log does not grow for the lifetime of the database.
+func (db *Database) newErrLog() string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.conn == nil {
+		return ""
+	}
+
+	var buf *C.char
+	var n C.int
+
+	res := C.config_err_log(db.conn, &buf, &n)
+	if res != C.UNQLITE_OK || n == 0 {
+		return ""
+	}
+
+	// File names carry the route to the VFS of the database, see routedName
+	s := routePattern.ReplaceAllString(C.GoStringN(buf, n), "")
+	C.config_err_log_reset(db.conn)
+
+	return strings.TrimSpace(s)
+}
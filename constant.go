@@ -0,0 +1,105 @@
+package unqlitego
+
+// #include <unqlite.h>
+// #include <wrappers.h>
+// #include <stdlib.h>
+import "C"
+
+import (
+	"fmt"
+	"runtime/cgo"
+	"strings"
+	"unsafe"
+)
+
+// DefineConstant defines the constant name of the compiled script, expanded by
+// calling fn each time the script reads it. fn returns a Go scalar, a fmt.Stringer
+// expands to its string and any other value to null.
+// A panic of fn aborts the script, Run and ExecuteContext return a *CallbackError.
+// Defining a name again replaces the previous function.
+func (vm *VM) DefineConstant(name string, fn func() interface{}) error {
+	name = strings.TrimSpace(name)
+	if name == "" || fn == nil {
+		return ErrInvalid
+	}
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	h := cgo.NewHandle(&constantFunc{name: name, fn: fn, vm: vm.vm, state: vm.callbackState()})
+	res := C.vm_create_constant(vm.vm, cname, C.uintptr_t(h))
+	if res != C.UNQLITE_OK {
+		h.Delete()
+		return newError("DefineConstant", []byte(name), res)
+	}
+
+	if vm.consts == nil {
+		vm.consts = make(map[string]cgo.Handle)
+	}
+	if old, ok := vm.consts[name]; ok {
+		old.Delete()
+	}
+	vm.consts[name] = h
+
+	return nil
+}
+
+// DeleteConstant removes the constant name from the compiled script.
+func (vm *VM) DeleteConstant(name string) error {
+	name = strings.TrimSpace(name)
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	res := C.unqlite_delete_constant(vm.vm, cname)
+	if res != C.UNQLITE_OK {
+		return newError("DeleteConstant", []byte(name), res)
+	}
+
+	if h, ok := vm.consts[name]; ok {
+		h.Delete()
+		delete(vm.consts, name)
+	}
+
+	return nil
+}
+
+// releaseConsts deletes the handles of the defined constants.
+func (vm *VM) releaseConsts() {
+	for name, h := range vm.consts {
+		h.Delete()
+		delete(vm.consts, name)
+	}
+}
+
+// constantFunc is the value of the handle of a defined constant.
+type constantFunc struct {
+	name  string
+	fn    func() interface{}
+	vm    *C.unqlite_vm
+	state *callbackState
+}
+
+//export goExpandConstant
+func goExpandConstant(v *C.unqlite_value, handle C.uintptr_t) {
+	c := cgo.Handle(handle).Value().(*constantFunc)
+
+	// A panic must not unwind through the VM. The expansion cannot fail,
+	// the script is interrupted instead.
+	defer func() {
+		if r := recover(); r != nil {
+			C.unqlite_value_null(v)
+			c.state.fail(c.name, panicError(r))
+			C.unqlite_vm_interrupt(c.vm)
+		}
+	}()
+
+	x := c.fn()
+	if err := setScalar(v, x); err != nil {
+		if s, ok := x.(fmt.Stringer); ok {
+			setString(v, s.String())
+			return
+		}
+		C.unqlite_value_null(v)
+	}
+}
@@ -0,0 +1,49 @@
+package unqlitego
+
+// #include <unqlite.h>
+// #include <wrappers.h>
+import "C"
+
+import (
+	"io"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// consumer adapts an io.Writer to the UnQLite consumer callback, which
+// hands out data in chunks instead of copying it in one buffer.
+type consumer struct {
+	// Destination Writer
+	w io.Writer
+
+	// Bytes written
+	n int64
+
+	// First write error
+	err error
+}
+
+// newConsumer creates a consumer and the handle to pass as callback user data.
+// The handle must be deleted when the callback is no longer used.
+func newConsumer(w io.Writer) (*consumer, cgo.Handle) {
+	c := &consumer{w: w}
+
+	return c, cgo.NewHandle(c)
+}
+
+//export goConsume
+func goConsume(data unsafe.Pointer, n C.uint, handle C.uintptr_t) C.int {
+	c := cgo.Handle(handle).Value().(*consumer)
+	if c.err != nil {
+		return C.UNQLITE_ABORT
+	}
+
+	written, err := c.w.Write(unsafe.Slice((*byte)(data), int(n)))
+	c.n += int64(written)
+	if err != nil {
+		c.err = err
+		return C.UNQLITE_ABORT
+	}
+
+	return C.UNQLITE_OK
+}
@@ -0,0 +1,263 @@
+package unqlitego
+
+// #include <unqlite.h>
+// #include <stdlib.h>
+import "C"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// valueAllocator creates JX9 values owned by a VM or by a foreign function call.
+type valueAllocator interface {
+	newScalar() *C.unqlite_value
+	newArray() *C.unqlite_value
+	release(v *C.unqlite_value)
+}
+
+// vmAllocator allocates values owned by a VM.
+type vmAllocator struct {
+	vm *C.unqlite_vm
+}
+
+func (a vmAllocator) newScalar() *C.unqlite_value {
+	return C.unqlite_vm_new_scalar(a.vm)
+}
+
+func (a vmAllocator) newArray() *C.unqlite_value {
+	return C.unqlite_vm_new_array(a.vm)
+}
+
+func (a vmAllocator) release(v *C.unqlite_value) {
+	C.unqlite_vm_release_value(a.vm, v)
+}
+
+// contextAllocator allocates values owned by a foreign function call.
+type contextAllocator struct {
+	ctx *C.unqlite_context
+}
+
+func (a contextAllocator) newScalar() *C.unqlite_value {
+	return C.unqlite_context_new_scalar(a.ctx)
+}
+
+func (a contextAllocator) newArray() *C.unqlite_value {
+	return C.unqlite_context_new_array(a.ctx)
+}
+
+func (a contextAllocator) release(v *C.unqlite_value) {
+	C.unqlite_context_release_value(a.ctx, v)
+}
+
+// newValue converts the Go value x into a JX9 value, which must be released by the caller.
+//
+// Scalars map to their JX9 counterpart, slices and arrays to JSON arrays and maps to
+// JSON objects. Structs and types implementing json.Marshaler are converted through
+// their JSON encoding, so json tags are honored. Unsigned integers above math.MaxInt64
+// do not fit a JX9 integer, ErrUnsupportedType is returned for them.
+func newValue(a valueAllocator, x interface{}) (*C.unqlite_value, error) {
+	if _, ok := x.(Value); ok {
+		// Values are copied by the engine, they are never converted.
+		return nil, ErrUnsupportedType
+	}
+
+	switch rv := reflect.ValueOf(x); rv.Kind() {
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: %d overflows int64", ErrUnsupportedType, rv.Uint())
+		}
+	}
+
+	if _, ok := x.(json.Marshaler); !ok {
+		v := a.newScalar()
+		if v == nil {
+			return nil, ErrNoMem
+		}
+		if err := setScalar(v, x); err == nil {
+			return v, nil
+		}
+		a.release(v)
+
+		rv := reflect.ValueOf(x)
+		switch rv.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			if rv.IsNil() {
+				return newValue(a, nil)
+			}
+			return newValue(a, rv.Elem().Interface())
+		case reflect.Slice, reflect.Array:
+			if rv.Kind() == reflect.Slice && rv.IsNil() {
+				return newValue(a, nil)
+			}
+			return newArrayValue(a, rv)
+		case reflect.Map:
+			if rv.IsNil() {
+				return newValue(a, nil)
+			}
+			return newMapValue(a, rv)
+		}
+	}
+
+	data, err := json.Marshal(x)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedType, err)
+	}
+
+	return newJSONValue(a, data)
+}
+
+// newArrayValue converts a Go slice or array into a JSON array.
+func newArrayValue(a valueAllocator, rv reflect.Value) (*C.unqlite_value, error) {
+	arr := a.newArray()
+	if arr == nil {
+		return nil, ErrNoMem
+	}
+
+	for i := 0; i < rv.Len(); i++ {
+		if err := addElem(a, arr, "", rv.Index(i).Interface()); err != nil {
+			a.release(arr)
+			return nil, err
+		}
+	}
+
+	return arr, nil
+}
+
+// newMapValue converts a Go map into a JSON object, keys are added in sorted order.
+func newMapValue(a valueAllocator, rv reflect.Value) (*C.unqlite_value, error) {
+	keys := make([]string, 0, rv.Len())
+	values := make(map[string]interface{}, rv.Len())
+	for _, k := range rv.MapKeys() {
+		s := fmt.Sprint(k.Interface())
+		keys = append(keys, s)
+		values[s] = rv.MapIndex(k).Interface()
+	}
+	sort.Strings(keys)
+
+	arr := a.newArray()
+	if arr == nil {
+		return nil, ErrNoMem
+	}
+
+	for _, k := range keys {
+		if err := addElem(a, arr, k, values[k]); err != nil {
+			a.release(arr)
+			return nil, err
+		}
+	}
+
+	return arr, nil
+}
+
+// addElem converts x and adds it to the array under key, an empty key appends it.
+// A Value is added as is.
+func addElem(a valueAllocator, arr *C.unqlite_value, key string, x interface{}) error {
+	v, ok := x.(Value)
+	if ok && v.v == nil {
+		x = nil
+	}
+	if v.v == nil {
+		cv, err := newValue(a, x)
+		if err != nil {
+			return err
+		}
+		// The array stores a copy of the element.
+		defer a.release(cv)
+
+		v = Value{v: cv}
+	}
+
+	var ckey *C.char
+	if key != "" {
+		ckey = C.CString(key)
+		defer C.free(unsafe.Pointer(ckey))
+	}
+
+	return resultError(C.unqlite_array_add_strkey_elem(arr, ckey, v.v))
+}
+
+// newJSONValue converts a JSON document into a JX9 value, keeping the order of object keys.
+func newJSONValue(a valueAllocator, data []byte) (*C.unqlite_value, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	return decodeJSONValue(a, dec)
+}
+
+// decodeJSONValue converts the next JSON value of the decoder.
+func decodeJSONValue(a valueAllocator, dec *json.Decoder) (*C.unqlite_value, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		arr := a.newArray()
+		if arr == nil {
+			return nil, ErrNoMem
+		}
+		if err := decodeJSONElems(a, dec, arr, tok == '{'); err != nil {
+			a.release(arr)
+			return nil, err
+		}
+		return arr, nil
+	case json.Number:
+		if i, err := tok.Int64(); err == nil {
+			return newValue(a, i)
+		}
+		if !strings.ContainsAny(tok.String(), ".eE") {
+			// An integer which does not fit a JX9 integer
+			return nil, fmt.Errorf("%w: %s overflows int64", ErrUnsupportedType, tok)
+		}
+		f, err := tok.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return newValue(a, f)
+	default:
+		return newValue(a, tok)
+	}
+}
+
+// decodeJSONElems adds the elements of a JSON array or object to arr, up to the closing delimiter.
+func decodeJSONElems(a valueAllocator, dec *json.Decoder, arr *C.unqlite_value, object bool) error {
+	for dec.More() {
+		var ckey *C.char
+		if object {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			ckey = C.CString(tok.(string))
+		}
+
+		v, err := decodeJSONValue(a, dec)
+		if err != nil {
+			C.free(unsafe.Pointer(ckey))
+			return err
+		}
+
+		res := C.unqlite_array_add_strkey_elem(arr, ckey, v)
+		a.release(v)
+		C.free(unsafe.Pointer(ckey))
+		if err := resultError(res); err != nil {
+			return err
+		}
+	}
+
+	// Closing delimiter
+	if _, err := dec.Token(); err != nil && err != io.EOF {
+		return err
+	}
+
+	return nil
+}
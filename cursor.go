@@ -6,6 +6,7 @@ package unqlitego
 import "C"
 
 import (
+	"io"
 	"runtime"
 	"unsafe"
 )
@@ -24,7 +25,7 @@ func (db *Database) Cursor() (*Cursor, error) {
 	c := &Cursor{db: db}
 	res := C.unqlite_kv_cursor_init(db.conn, &c.handle)
 	if res != C.UNQLITE_OK {
-		return nil, UnQLiteError(res)
+		return nil, db.error("Cursor", nil, res)
 	}
 	runtime.SetFinalizer(c, (*Cursor).Close)
 
@@ -32,14 +33,23 @@ func (db *Database) Cursor() (*Cursor, error) {
 }
 
 // Close closes the open cursor.
+// The cursors of a database are released with it when the database is closed.
 func (cr *Cursor) Close() error {
-	if cr.db.conn != nil && cr.handle != nil {
-		res := C.unqlite_kv_cursor_release(cr.db.conn, cr.handle)
-		if res != C.UNQLITE_OK {
-			return UnQLiteError(res)
-		}
+	// The finalizer may run while the database is closed concurrently.
+	cr.db.mu.Lock()
+	if cr.db.conn == nil || cr.handle == nil {
+		cr.db.mu.Unlock()
+		return nil
+	}
+	res := C.unqlite_kv_cursor_release(cr.db.conn, cr.handle)
+	if res == C.UNQLITE_OK {
 		cr.handle = nil
 	}
+	cr.db.mu.Unlock()
+
+	if res != C.UNQLITE_OK {
+		return cr.db.error("Cursor.Close", nil, res)
+	}
 
 	return nil
 }
@@ -55,7 +65,7 @@ func (cr *Cursor) Seek(key []byte) error {
 
 	res := C.unqlite_kv_cursor_seek(cr.handle, k, C.int(len(key)), C.UNQLITE_CURSOR_MATCH_EXACT)
 	if res != C.UNQLITE_OK {
-		return UnQLiteError(res)
+		return cr.db.error("Cursor.Seek", key, res)
 	}
 
 	return nil
@@ -74,7 +84,7 @@ func (cr *Cursor) SeekLE(key []byte) error {
 
 	res := C.unqlite_kv_cursor_seek(cr.handle, k, C.int(len(key)), C.UNQLITE_CURSOR_MATCH_LE)
 	if res != C.UNQLITE_OK {
-		return UnQLiteError(res)
+		return cr.db.error("Cursor.SeekLE", key, res)
 	}
 
 	return nil
@@ -93,7 +103,7 @@ func (cr *Cursor) SeekGE(key []byte) error {
 
 	res := C.unqlite_kv_cursor_seek(cr.handle, k, C.int(len(key)), C.UNQLITE_CURSOR_MATCH_GE)
 	if res != C.UNQLITE_OK {
-		return UnQLiteError(res)
+		return cr.db.error("Cursor.SeekGE", key, res)
 	}
 
 	return nil
@@ -103,7 +113,7 @@ func (cr *Cursor) SeekGE(key []byte) error {
 func (cr *Cursor) First() error {
 	res := C.unqlite_kv_cursor_first_entry(cr.handle)
 	if res != C.UNQLITE_OK {
-		return UnQLiteError(res)
+		return cr.db.error("Cursor.First", nil, res)
 	}
 
 	return nil
@@ -113,7 +123,7 @@ func (cr *Cursor) First() error {
 func (cr *Cursor) Last() error {
 	res := C.unqlite_kv_cursor_last_entry(cr.handle)
 	if res != C.UNQLITE_OK {
-		return UnQLiteError(res)
+		return cr.db.error("Cursor.Last", nil, res)
 	}
 
 	return nil
@@ -128,7 +138,7 @@ func (cr *Cursor) IsValid() bool {
 func (cr *Cursor) Next() error {
 	res := C.unqlite_kv_cursor_next_entry(cr.handle)
 	if res != C.UNQLITE_OK {
-		return UnQLiteError(res)
+		return cr.db.error("Cursor.Next", nil, res)
 	}
 
 	return nil
@@ -138,7 +148,7 @@ func (cr *Cursor) Next() error {
 func (cr *Cursor) Prev() error {
 	res := C.unqlite_kv_cursor_prev_entry(cr.handle)
 	if res != C.UNQLITE_OK {
-		return UnQLiteError(res)
+		return cr.db.error("Cursor.Prev", nil, res)
 	}
 
 	return nil
@@ -148,7 +158,7 @@ func (cr *Cursor) Prev() error {
 func (cr *Cursor) Delete() error {
 	res := C.unqlite_kv_cursor_delete_entry(cr.handle)
 	if res != C.UNQLITE_OK {
-		return UnQLiteError(res)
+		return cr.db.error("Cursor.Delete", nil, res)
 	}
 
 	return nil
@@ -158,7 +168,7 @@ func (cr *Cursor) Delete() error {
 func (cr *Cursor) Reset() error {
 	res := C.unqlite_kv_cursor_reset(cr.handle)
 	if res != C.UNQLITE_OK {
-		return UnQLiteError(res)
+		return cr.db.error("Cursor.Reset", nil, res)
 	}
 
 	return nil
@@ -170,13 +180,13 @@ func (cr *Cursor) Key() (key []byte, err error) {
 
 	res := C.unqlite_kv_cursor_key(cr.handle, nil, &n)
 	if res != C.UNQLITE_OK {
-		return nil, UnQLiteError(res)
+		return nil, cr.db.error("Cursor.Key", nil, res)
 	}
 
 	key = make([]byte, int(n))
 	res = C.unqlite_kv_cursor_key(cr.handle, unsafe.Pointer(&key[0]), &n)
 	if res != C.UNQLITE_OK {
-		return nil, UnQLiteError(res)
+		return nil, cr.db.error("Cursor.Key", nil, res)
 	}
 
 	return
@@ -188,14 +198,51 @@ func (cr *Cursor) Value() (value []byte, err error) {
 
 	res := C.unqlite_kv_cursor_data(cr.handle, nil, &n)
 	if res != C.UNQLITE_OK {
-		return nil, UnQLiteError(res)
+		return nil, cr.db.error("Cursor.Value", nil, res)
 	}
 
 	value = make([]byte, int(n))
+	if n == 0 {
+		return
+	}
 	res = C.unqlite_kv_cursor_data(cr.handle, unsafe.Pointer(&value[0]), &n)
 	if res != C.UNQLITE_OK {
-		return nil, UnQLiteError(res)
+		return nil, cr.db.error("Cursor.Value", nil, res)
 	}
 
 	return
 }
+
+// KeyTo streams the key at the current cursor location into w.
+// It returns the number of bytes written to w.
+func (cr *Cursor) KeyTo(w io.Writer) (int64, error) {
+	c, h := newConsumer(w)
+	defer h.Delete()
+
+	res := C.kv_cursor_key_to(cr.handle, C.uintptr_t(h))
+	if c.err != nil {
+		return c.n, c.err
+	}
+	if res != C.UNQLITE_OK {
+		return c.n, cr.db.error("Cursor.KeyTo", nil, res)
+	}
+
+	return c.n, nil
+}
+
+// ValueTo streams the value at the current cursor position into w, without holding
+// the whole value in memory. It returns the number of bytes written to w.
+func (cr *Cursor) ValueTo(w io.Writer) (int64, error) {
+	c, h := newConsumer(w)
+	defer h.Delete()
+
+	res := C.kv_cursor_data_to(cr.handle, C.uintptr_t(h))
+	if c.err != nil {
+		return c.n, c.err
+	}
+	if res != C.UNQLITE_OK {
+		return c.n, cr.db.error("Cursor.ValueTo", nil, res)
+	}
+
+	return c.n, nil
+}
@@ -6,75 +6,253 @@ package unqlitego
 import "C"
 
 import (
+	"container/list"
+	"io"
+	"path/filepath"
 	"runtime"
+	"sync"
 	"unsafe"
 )
 
 // Database represents a UnQLite Database.
 type Database struct {
 	conn *C.unqlite
+
+	// Open Mode
+	mode Mode
+
+	// Writable transaction lock
+	wlock chan struct{}
+
+	// Key/Value comparison and hash function slot
+	slot int
+
+	// Serializes closing the database with releasing its VMs, guards progs
+	// and the error log. A pointer, so copies of the Database share it.
+	mu *sync.Mutex
+
+	// Prepared programs by script text, and their use order
+	progs   map[string]*Program
+	progLRU *list.List
+
+	// Encryption of the database files, nil when not encrypted
+	codec *codec
+
+	// VFS of the database files, nil for the VFS of the library
+	vfs *dbVFS
 }
 
 // NewDatabase creates and initalizes a new UnQLite database connection.
+// The database is created if it does not exist.
 func NewDatabase(filename string) (db *Database, err error) {
-	db = &Database{}
+	return OpenDatabase(filename)
+}
 
-	name := C.CString(filename)
-	defer C.free(unsafe.Pointer(name))
+// OpenDatabase opens a UnQLite database connection configured by the given Options.
+// Without any access mode Option the database is opened with Create.
+func OpenDatabase(filename string, opts ...Option) (db *Database, err error) {
+	o, err := newOptions(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	db = &Database{
+		mode:  o.mode,
+		wlock: make(chan struct{}, 1),
+		slot:  -1,
+		mu:    &sync.Mutex{},
+	}
 
 	// Initialize Native Library
 	if !Info().IsInitialized() {
 		Info().Init()
 	}
 
-	res := C.unqlite_open(&db.conn, name, C.UNQLITE_OPEN_CREATE)
+	var vfs *C.unqlite_vfs
+	if o.vfs != nil {
+		if db.vfs, err = newDBVFS(o.vfs); err != nil {
+			return nil, err
+		}
+		vfs = db.vfs.vfs
+	}
+	if o.keys != nil {
+		if db.codec, err = newCodec(o.keys, vfs); err != nil {
+			db.releaseVFS()
+			return nil, err
+		}
+		vfs = db.codec.vfs
+	}
+	if vfs != nil && !o.mode.Has(ModeInMemory) {
+		filename = routedName(filename, vfs)
+	}
+
+	name := C.CString(filename)
+	defer C.free(unsafe.Pointer(name))
+
+	res := C.unqlite_open(&db.conn, name, C.uint(o.mode))
 	if res != C.UNQLITE_OK {
-		err = UnQLiteError(res)
+		db.releaseVFS()
+		return nil, newError("Open", nil, res)
 	}
-	if db.conn != nil {
-		runtime.SetFinalizer(db, (*Database).Close)
+	runtime.SetFinalizer(db, (*Database).Close)
+
+	if db.codec != nil {
+		// The engine opens the file on first use, open it now to report a wrong key.
+		res = C.unqlite_begin(db.conn)
+		if res == C.UNQLITE_OK {
+			res = C.unqlite_rollback(db.conn)
+		}
+		if res == C.UNQLITE_READ_ONLY {
+			db.newErrLog()
+		} else if res != C.UNQLITE_OK {
+			err = db.error("Open", nil, res)
+			db.Close()
+			return nil, err
+		}
 	}
 
-	return
+	if o.config != nil {
+		if err = db.Config(*o.config); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	if o.hash != nil {
+		if err = db.SetHashFunc(o.hash); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	if o.cmp != nil {
+		if err = db.SetComparator(o.cmp); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+// Mode returns the flags the database was opened with.
+func (db *Database) Mode() Mode {
+	return db.mode
 }
 
 // Compile a JX9 Script into a Virtual Machine.
+// On a compile error the JX9 error log is returned next to a *CompileError.
 func (db *Database) Compile(jx9 string, vm *VM) (string, error) {
+	// Release a previously compiled program.
+	if err := vm.Close(); err != nil {
+		return "", err
+	}
 
-	res := C.unqlite_compile(db.conn, C.CString(jx9), C.int(len(jx9)), &vm.vm)
-	if res != C.UNQLITE_OK {
-		if res == C.UNQLITE_COMPILE_ERR {
-			err := UnQLiteError(res)
+	src := C.CString(jx9)
+	defer C.free(unsafe.Pointer(src))
+
+	res := C.unqlite_compile(db.conn, src, C.int(len(jx9)), &vm.vm)
+
+	return db.compiled("Compile", vm, res)
+}
+
+// CompileFile compiles the JX9 script file at path into a Virtual Machine.
+// The directory of the script is added to the import paths of the VM.
+// On a compile error the JX9 error log is returned next to the error.
+func (db *Database) CompileFile(path string, vm *VM) (string, error) {
+	if err := vm.Close(); err != nil {
+		return "", err
+	}
+
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
 
-			// Error Log
-			elog := new(C.char)
+	res := C.unqlite_compile_file(db.conn, cpath, &vm.vm)
+	if log, err := db.compiled("CompileFile", vm, res); err != nil {
+		return log, err
+	}
 
-			// Error Message
-			emsg := C.extract_unqlite_log_error(db.conn, elog)
+	if err := vm.AddImportPath(filepath.Dir(path)); err != nil {
+		vm.Close()
+		return "", err
+	}
 
+	return "", nil
+}
+
+// compiled completes the compilation of vm with the result res.
+func (db *Database) compiled(op string, vm *VM, res C.int) (string, error) {
+	if res != C.UNQLITE_OK {
+		vm.vm = nil
+		if res == C.UNQLITE_COMPILE_ERR {
 			// Global Error Message
-			gmsg := C.GoString(emsg)
+			err := newCompileError(op, db.jx9ErrLog())
 
-			return gmsg, err
+			return err.Log, err
 		}
+
+		return "", newError(op, nil, res)
 	}
 
+	vm.db = db
+	runtime.SetFinalizer(vm, (*VM).Close)
+
 	return "", nil
 }
 
 // Close will close the database connection.
+// VMs compiled against the database are released with it and must not be used afterwards.
 func (db *Database) Close() (err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	if db.conn != nil {
 		res := C.unqlite_close(db.conn)
 		if res != C.UNQLITE_OK {
-			err = UnQLiteError(res)
+			err = newError("Close", nil, res)
 		}
 		db.conn = nil
+		db.progs, db.progLRU = nil, nil
+		db.releaseKVSlot()
+		db.releaseVFS()
 	}
 
 	return
 }
 
+// releaseVFS frees the VFS the database was opened with.
+func (db *Database) releaseVFS() {
+	if db.codec != nil {
+		db.codec.release()
+		db.codec = nil
+	}
+	if db.vfs != nil {
+		db.vfs.release()
+		db.vfs = nil
+	}
+}
+
+// closed returns a boolean indicating if the database is closed.
+func (db *Database) closed() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.conn == nil
+}
+
+// releaseVM releases a VM compiled against the database,
+// unless closing the database already released it.
+func (db *Database) releaseVM(vm *C.unqlite_vm) C.int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.conn == nil {
+		return C.UNQLITE_OK
+	}
+
+	return C.unqlite_vm_release(vm)
+}
+
 // Store will store a new Key/Value pair in the database.
 func (db *Database) Store(key, value []byte) (err error) {
 	var k, v unsafe.Pointer
@@ -94,7 +272,7 @@ func (db *Database) Store(key, value []byte) (err error) {
 		return nil
 	}
 
-	return UnQLiteError(res)
+	return db.error("Store", key, res)
 }
 
 // Append will write a new record into the database.
@@ -116,7 +294,7 @@ func (db *Database) Append(key, value []byte) (err error) {
 		v, C.unqlite_int64(len(value)))
 
 	if res != C.UNQLITE_OK {
-		err = UnQLiteError(res)
+		err = db.error("Append", key, res)
 	}
 	return
 }
@@ -132,16 +310,74 @@ func (db *Database) Fetch(key []byte) (value []byte, err error) {
 	var n C.unqlite_int64
 	res := C.unqlite_kv_fetch(db.conn, k, C.int(len(key)), nil, &n)
 	if res != C.UNQLITE_OK {
-		err = UnQLiteError(res)
+		err = db.error("Fetch", key, res)
 		return
 	}
 
 	value = make([]byte, int(n))
+	if n == 0 {
+		return
+	}
 	res = C.unqlite_kv_fetch(db.conn, k, C.int(len(key)), unsafe.Pointer(&value[0]), &n)
 	if res != C.UNQLITE_OK {
-		err = UnQLiteError(res)
+		err = db.error("Fetch", key, res)
+	}
+
+	return
+}
+
+// FetchTo streams a record from the database into w, without holding the whole value in memory.
+// It returns the number of bytes written to w.
+func (db *Database) FetchTo(key []byte, w io.Writer) (int64, error) {
+	var k unsafe.Pointer
+
+	if len(key) > 0 {
+		k = unsafe.Pointer(&key[0])
+	}
+
+	c, h := newConsumer(w)
+	defer h.Delete()
+
+	res := C.kv_fetch_to(db.conn, k, C.int(len(key)), C.uintptr_t(h))
+	if c.err != nil {
+		return c.n, c.err
+	}
+	if res != C.UNQLITE_OK {
+		return c.n, db.error("FetchTo", key, res)
 	}
 
+	return c.n, nil
+}
+
+// ValueSize returns the length of a record without reading its data.
+func (db *Database) ValueSize(key []byte) (int64, error) {
+	var k unsafe.Pointer
+
+	if len(key) > 0 {
+		k = unsafe.Pointer(&key[0])
+	}
+
+	var n C.unqlite_int64
+	res := C.unqlite_kv_fetch(db.conn, k, C.int(len(key)), nil, &n)
+	if res != C.UNQLITE_OK {
+		return 0, db.error("ValueSize", key, res)
+	}
+
+	return int64(n), nil
+}
+
+// StoreFrom will store a record read from r in chunks, without holding the whole value in memory.
+// The record is written within a writable transaction, which is rolled back when reading from r fails.
+// It returns the number of bytes stored.
+//
+// StoreFrom returns ErrTxOpen while a writable transaction is open, instead of waiting
+// for it and deadlocking when called from within it. Use Tx.StoreFrom within a transaction.
+func (db *Database) StoreFrom(key []byte, r io.Reader) (n int64, err error) {
+	err = db.update(func(tx *Tx) error {
+		n, err = tx.StoreFrom(key, r)
+		return err
+	}, false)
+
 	return
 }
 
@@ -155,7 +391,7 @@ func (db *Database) Delete(key []byte) (err error) {
 
 	res := C.unqlite_kv_delete(db.conn, k, C.int(len(key)))
 	if res != C.UNQLITE_OK {
-		err = UnQLiteError(res)
+		err = db.error("Delete", key, res)
 	}
 
 	return
@@ -165,7 +401,7 @@ func (db *Database) Delete(key []byte) (err error) {
 func (db *Database) Begin() (err error) {
 	res := C.unqlite_begin(db.conn)
 	if res != C.UNQLITE_OK {
-		err = UnQLiteError(res)
+		err = db.error("Begin", nil, res)
 	}
 
 	return
@@ -175,7 +411,7 @@ func (db *Database) Begin() (err error) {
 func (db *Database) Commit() (err error) {
 	res := C.unqlite_commit(db.conn)
 	if res != C.UNQLITE_OK {
-		err = UnQLiteError(res)
+		err = db.error("Commit", nil, res)
 	}
 
 	return
@@ -185,7 +421,7 @@ func (db *Database) Commit() (err error) {
 func (db *Database) Rollback() (err error) {
 	res := C.unqlite_rollback(db.conn)
 	if res != C.UNQLITE_OK {
-		err = UnQLiteError(res)
+		err = db.error("Rollback", nil, res)
 	}
 
 	return
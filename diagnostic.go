@@ -0,0 +1,201 @@
+package unqlitego
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Severity is the level of a JX9 diagnostic.
+type Severity int
+
+// JX9 diagnostic levels
+const (
+	SeverityError Severity = iota
+	SeverityParse
+	SeverityWarning
+	SeverityNotice
+)
+
+// severityNames are the names the engine writes for each level.
+var severityNames = [...]string{
+	SeverityError:   "Error",
+	SeverityParse:   "Parse error",
+	SeverityWarning: "Warning",
+	SeverityNotice:  "Notice",
+}
+
+// String returns the name of the level as written by the engine.
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return "Severity(" + strconv.Itoa(int(s)) + ")"
+	}
+
+	return severityNames[s]
+}
+
+// Diagnostic is a message of the JX9 compiler or of a running script.
+type Diagnostic struct {
+	// Script file, empty when unknown
+	File string
+
+	// Line number, zero when unknown. Runtime messages carry no line.
+	Line int
+
+	// Level of the message
+	Severity Severity
+
+	// Text of the message
+	Message string
+}
+
+// String formats the diagnostic like the engine does.
+func (d Diagnostic) String() string {
+	var s string
+	if d.File != "" {
+		s += d.File + " "
+	}
+	if d.Line > 0 {
+		s += strconv.Itoa(d.Line) + " "
+	}
+
+	return s + d.Severity.String() + ": " + d.Message
+}
+
+// CompileError is returned when a JX9 script fails to compile.
+// It unwraps to the *Error of the compilation, and so to ErrCompile.
+type CompileError struct {
+	// Text of the JX9 compile error log
+	Log string
+
+	// Messages parsed from the log, in order
+	Diagnostics []Diagnostic
+
+	// Underlying error
+	err *Error
+}
+
+// Error returns the message of the compile error.
+func (e *CompileError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the *Error of the compilation.
+func (e *CompileError) Unwrap() error {
+	return e.err
+}
+
+// newCompileError creates the CompileError of a failed compilation from its log.
+func newCompileError(op, log string) *CompileError {
+	return &CompileError{
+		Log:         log,
+		Diagnostics: parseCompileLog(log),
+		err:         &Error{Op: op, Code: ErrCompile, Log: log},
+	}
+}
+
+// RuntimeError is returned by Run when a script with error reporting enabled
+// raised errors. Warnings and notices of the execution are listed as well.
+type RuntimeError struct {
+	// Messages raised by the script, in order
+	Diagnostics []Diagnostic
+}
+
+// Error returns the first error raised by the script.
+func (e *RuntimeError) Error() string {
+	var first string
+	n := 0
+	for _, d := range e.Diagnostics {
+		if d.Severity != SeverityError {
+			continue
+		}
+		if n == 0 {
+			first = d.String()
+		}
+		n++
+	}
+
+	if n > 1 {
+		return fmt.Sprintf("Execute: %s (and %d more errors)", first, n-1)
+	}
+
+	return "Execute: " + first
+}
+
+// parseCompileLog parses the lines of a compile error log, "<line> <severity>: <message>".
+// Lines without a line number are kept as errors.
+func parseCompileLog(log string) []Diagnostic {
+	var diags []Diagnostic
+	for _, l := range strings.Split(log, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+
+		d := Diagnostic{Severity: SeverityError, Message: l}
+		if i := strings.IndexByte(l, ' '); i > 0 {
+			if line, err := strconv.Atoi(l[:i]); err == nil {
+				d.Line = line
+				d.Message = l[i+1:]
+			}
+		}
+		for s, name := range severityNames {
+			if strings.HasPrefix(d.Message, name+": ") {
+				d.Severity = Severity(s)
+				d.Message = d.Message[len(name)+2:]
+				break
+			}
+		}
+		diags = append(diags, d)
+	}
+
+	return diags
+}
+
+// parseRuntimeError parses a runtime message, "[<file> ]<severity>: <message>".
+func parseRuntimeError(msg string) Diagnostic {
+	msg = strings.TrimRight(msg, "\r\n")
+
+	d := Diagnostic{Severity: SeverityError, Message: msg}
+	at := len(msg)
+	for _, s := range []Severity{SeverityError, SeverityWarning, SeverityNotice} {
+		tag := s.String() + ": "
+		i := 0
+		if !strings.HasPrefix(msg, tag) {
+			if i = strings.Index(msg, " "+tag); i < 0 {
+				continue
+			}
+		}
+		if i < at {
+			at = i
+			d.Severity = s
+			d.File = msg[:i]
+			d.Message = strings.TrimPrefix(msg[i:], " ")[len(tag):]
+		}
+	}
+
+	return d
+}
+
+// diagnostics collects the runtime messages of a VM, the engine writes one message at a time.
+type diagnostics struct {
+	list []Diagnostic
+}
+
+// Write records the message p.
+func (d *diagnostics) Write(p []byte) (int, error) {
+	d.list = append(d.list, parseRuntimeError(string(p)))
+
+	return len(p), nil
+}
+
+// err returns a *RuntimeError when an error was recorded.
+func (d *diagnostics) err() error {
+	for _, diag := range d.list {
+		if diag.Severity == SeverityError {
+			return &RuntimeError{Diagnostics: d.list}
+		}
+	}
+
+	return nil
+}
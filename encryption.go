@@ -0,0 +1,643 @@
+package unqlitego
+
+// #include <unqlite.h>
+// #include <wrappers.h>
+// #include <stdlib.h>
+import "C"
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// Layout of an encrypted file. The file starts with a header followed by blocks of
+// codecBlockSize bytes of content, each sealed with AES-256-GCM under its own nonce.
+// The last block may be shorter. An empty file has no header.
+const (
+	codecKeySize    = 32
+	codecBlockSize  = 4096
+	codecOverhead   = 12 + 16 // Nonce and tag
+	codecPhysBlock  = codecBlockSize + codecOverhead
+	codecHeaderSize = 512
+)
+
+// Offsets within the header.
+const (
+	hdrState   = 8   // 0 when sealed with a single key, 1 during a rekey
+	hdrSalt    = 16  // Random salt of the file, authenticated with every block
+	hdrCheck   = 32  // Key check of the active key, the old one during a rekey
+	hdrCheckB  = 60  // Key check of the new key during a rekey
+	hdrWrapNew = 88  // New key sealed under the old one during a rekey
+	hdrWrapOld = 148 // Old key sealed under the new one during a rekey
+	hdrEnd     = 208
+)
+
+// codecMagic identifies an encrypted file.
+var codecMagic = []byte("UQLGOENC")
+
+// KeyProvider returns the key a database is encrypted with. It is called once
+// when the database is opened, the key must be 32 bytes long.
+type KeyProvider func() ([]byte, error)
+
+// WithEncryption encrypts the database file and its rollback journal with
+// AES-256-GCM under the key returned by keys. Each 4KiB block of a file is sealed
+// under a random nonce and authenticated with its position, so blocks can not be
+// altered or moved. Opening an existing database with another key returns ErrWrongKey.
+//
+// Random 96-bit nonces keep the chance of a repeated nonce negligible for up to 2^32
+// block writes under one key, about 16TiB written to the database and its journal.
+// Change the key with Rekey before a database reaches that limit.
+//
+// Encryption requires a database file, it can not be combined with InMemory or MMap.
+func WithEncryption(keys KeyProvider) Option {
+	return func(o *options) {
+		o.keys = keys
+	}
+}
+
+// codec encrypts the files of a database, it backs the VFS the database is opened with.
+type codec struct {
+	// Current key and its cipher
+	key  []byte
+	aead cipher.AEAD
+
+	// Encrypting VFS and the handle of the codec it carries
+	vfs    *C.unqlite_vfs
+	handle cgo.Handle
+
+	// Database file, the engine opens it before any journal
+	main *codecFile
+
+	// Error detected by the last open of a file, reported by Database.error
+	err UnQLiteError
+}
+
+// codecFile is a file opened through the encrypting VFS.
+type codecFile struct {
+	c    *codec
+	real *C.unqlite_file
+
+	// Salt of the file, nil until the header is read or written
+	salt []byte
+
+	// Cipher sealing blocks and the cipher of the other key of an unfinished rekey
+	aead cipher.AEAD
+	alt  cipher.AEAD
+}
+
+// newCodec creates the codec and its VFS for the key returned by keys.
+// The codec wraps base, or the VFS of the library when base is nil.
+func newCodec(keys KeyProvider, base *C.unqlite_vfs) (*codec, error) {
+	key, err := keys()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &codec{key: append([]byte{}, key...), aead: aead}
+	c.handle = cgo.NewHandle(c)
+	c.vfs = C.codec_vfs_new(C.uintptr_t(c.handle), base)
+	if c.vfs == nil {
+		c.handle.Delete()
+		return nil, newError("Open", nil, C.UNQLITE_NOMEM)
+	}
+
+	return c, nil
+}
+
+// release frees the VFS of the codec once the database is closed.
+func (c *codec) release() {
+	C.free(unsafe.Pointer(c.vfs))
+	c.handle.Delete()
+}
+
+// newAEAD returns the AES-256-GCM cipher of key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != codecKeySize {
+		return nil, ErrInvalidConfig
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts p with a random nonce, the nonce is prepended to the result.
+// A key must seal no more than 2^32 messages, see WithEncryption.
+func seal(aead cipher.AEAD, p, ad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(p)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, p, ad), nil
+}
+
+// unseal decrypts b as returned by seal.
+func unseal(aead cipher.AEAD, b, ad []byte) ([]byte, error) {
+	n := aead.NonceSize()
+
+	return aead.Open(nil, b[:n], b[n:], ad)
+}
+
+// rekey re-encrypts the database file with key. The caller holds a write transaction.
+func (c *codec) rekey(key []byte) (res C.int) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return C.UNQLITE_INVALID
+	}
+
+	if f := c.main; f != nil {
+		if f.alt != nil {
+			// The file is left in the middle of a rekey, the next open finishes it.
+			// Starting another one now would lose the other key.
+			return C.UNQLITE_LOCKED
+		}
+
+		level := C.codec_lock_level(f.real)
+		if res = C.codec_real_lock(f.real, C.UNQLITE_LOCK_EXCLUSIVE); res != C.UNQLITE_OK {
+			return res
+		}
+		defer func() {
+			if r := f.restoreLock(level); res == C.UNQLITE_OK {
+				res = r
+			}
+		}()
+
+		if res = f.rekey(c.key, key); res != C.UNQLITE_OK {
+			// Roll the file back to the current key. Should that fail as well,
+			// the header holds both keys and the next open finishes the file.
+			f.rekey(key, c.key)
+			return res
+		}
+	}
+	c.key = append([]byte{}, key...)
+	c.aead = aead
+
+	return C.UNQLITE_OK
+}
+
+// inTx reports whether the engine holds a write transaction on the database file.
+func (c *codec) inTx() bool {
+	return c.main != nil && C.codec_lock_level(c.main.real) >= C.UNQLITE_LOCK_RESERVED
+}
+
+// restoreLock takes the lock of the file back to level, the level the engine holds.
+// A lock is only released down to SHARED, a RESERVED lock is then taken again.
+func (f *codecFile) restoreLock(level C.int) C.int {
+	if level >= C.UNQLITE_LOCK_EXCLUSIVE {
+		return C.UNQLITE_OK
+	}
+	if level == C.UNQLITE_LOCK_NONE {
+		return C.codec_real_unlock(f.real, C.UNQLITE_LOCK_NONE)
+	}
+
+	res := C.codec_real_unlock(f.real, C.UNQLITE_LOCK_SHARED)
+	if res != C.UNQLITE_OK || level == C.UNQLITE_LOCK_SHARED {
+		return res
+	}
+
+	return C.codec_real_lock(f.real, level)
+}
+
+// physSize returns the size of the underlying file.
+func (f *codecFile) physSize() (int64, C.int) {
+	var n C.unqlite_int64
+	res := C.codec_real_size(f.real, &n)
+
+	return int64(n), res
+}
+
+// size returns the size of the content of the file.
+func (f *codecFile) size() (int64, C.int) {
+	phys, res := f.physSize()
+	if res != C.UNQLITE_OK || phys <= codecHeaderSize {
+		return 0, res
+	}
+
+	phys -= codecHeaderSize
+	n := phys / codecPhysBlock * codecBlockSize
+	if rem := phys % codecPhysBlock; rem > codecOverhead {
+		n += rem - codecOverhead
+	}
+
+	return n, C.UNQLITE_OK
+}
+
+// readHeader reads and checks the header of the file against the key of the codec.
+func (f *codecFile) readHeader(writable bool) C.int {
+	hdr := make([]byte, codecHeaderSize)
+	if res := C.codec_real_read(f.real, unsafe.Pointer(&hdr[0]), codecHeaderSize, 0); res != C.UNQLITE_OK {
+		return res
+	}
+	if !bytes.Equal(hdr[:len(codecMagic)], codecMagic) {
+		f.c.err = ErrWrongKey
+		return C.UNQLITE_PERM
+	}
+	f.salt = hdr[hdrSalt:hdrCheck]
+
+	if hdr[hdrState] == 0 {
+		if !f.checkKey(f.aead, hdr[hdrCheck:hdrCheckB]) {
+			f.c.err = ErrWrongKey
+			return C.UNQLITE_PERM
+		}
+
+		return C.UNQLITE_OK
+	}
+
+	// An unfinished rekey, the file holds blocks sealed under both keys. Either key
+	// recovers the other one, the file is finished with the key it is opened with.
+	var other []byte
+	var err error
+	switch {
+	case f.checkKey(f.aead, hdr[hdrCheck:hdrCheckB]):
+		other, err = unseal(f.aead, hdr[hdrWrapNew:hdrWrapOld], f.salt)
+	case f.checkKey(f.aead, hdr[hdrCheckB:hdrWrapNew]):
+		other, err = unseal(f.aead, hdr[hdrWrapOld:hdrEnd], f.salt)
+	default:
+		f.c.err = ErrWrongKey
+		return C.UNQLITE_PERM
+	}
+	if err != nil {
+		return C.UNQLITE_CORRUPT
+	}
+	if f.alt, err = newAEAD(other); err != nil {
+		return C.UNQLITE_CORRUPT
+	}
+	if !writable {
+		return C.UNQLITE_OK
+	}
+
+	// Only finish the rekey when no other process uses the file
+	if C.codec_real_lock(f.real, C.UNQLITE_LOCK_SHARED) != C.UNQLITE_OK {
+		return C.UNQLITE_OK
+	}
+	defer C.codec_real_unlock(f.real, C.UNQLITE_LOCK_NONE)
+	if C.codec_real_lock(f.real, C.UNQLITE_LOCK_EXCLUSIVE) != C.UNQLITE_OK {
+		return C.UNQLITE_OK
+	}
+
+	return f.rekey(other, f.c.key)
+}
+
+// checkKey reports whether check is a key check sealed with the key of aead.
+func (f *codecFile) checkKey(aead cipher.AEAD, check []byte) bool {
+	_, err := unseal(aead, check, f.salt)
+
+	return err == nil
+}
+
+// writeHeader writes the header of the file, a header of a rekey from oldKey
+// to newKey when oldKey is set.
+func (f *codecFile) writeHeader(oldKey, newKey []byte) C.int {
+	hdr := make([]byte, codecHeaderSize)
+	copy(hdr, codecMagic)
+	copy(hdr[hdrSalt:], f.salt)
+
+	// put seals p at off, the first error is kept. A key check is an empty
+	// message, only the key it was sealed with opens it.
+	var err error
+	put := func(aead cipher.AEAD, p []byte, off int) {
+		var b []byte
+		if err == nil {
+			b, err = seal(aead, p, f.salt)
+			copy(hdr[off:], b)
+		}
+	}
+	if oldKey == nil {
+		put(f.aead, nil, hdrCheck)
+	} else {
+		from, _ := newAEAD(oldKey)
+		to, _ := newAEAD(newKey)
+		hdr[hdrState] = 1
+		put(from, nil, hdrCheck)
+		put(to, nil, hdrCheckB)
+		put(from, newKey, hdrWrapNew)
+		put(to, oldKey, hdrWrapOld)
+	}
+	if err != nil {
+		return C.UNQLITE_IOERR
+	}
+
+	return C.codec_real_write(f.real, unsafe.Pointer(&hdr[0]), codecHeaderSize, 0)
+}
+
+// rekey re-encrypts the blocks of the file from oldKey to newKey. The header records
+// both keys until all blocks are rewritten, so a crash in the middle can be recovered.
+func (f *codecFile) rekey(oldKey, newKey []byte) C.int {
+	from, _ := newAEAD(oldKey)
+	to, _ := newAEAD(newKey)
+
+	phys, res := f.physSize()
+	if res != C.UNQLITE_OK || phys == 0 {
+		f.aead, f.alt = to, nil
+		return res
+	}
+
+	if res = f.writeHeader(oldKey, newKey); res != C.UNQLITE_OK {
+		return res
+	}
+	if res = C.codec_real_sync(f.real); res != C.UNQLITE_OK {
+		return res
+	}
+
+	f.aead, f.alt = to, from
+	n := (phys - codecHeaderSize + codecPhysBlock - 1) / codecPhysBlock
+	for i := int64(0); i < n; i++ {
+		b, res := f.readBlock(i, phys)
+		if res != C.UNQLITE_OK {
+			return res
+		}
+		if res = f.writeBlock(i, b); res != C.UNQLITE_OK {
+			return res
+		}
+	}
+	if res = C.codec_real_sync(f.real); res != C.UNQLITE_OK {
+		return res
+	}
+
+	if res = f.writeHeader(nil, nil); res != C.UNQLITE_OK {
+		return res
+	}
+	f.alt = nil
+
+	return C.codec_real_sync(f.real)
+}
+
+// blockAD returns the additional data authenticated with block i.
+func (f *codecFile) blockAD(i int64) []byte {
+	ad := make([]byte, len(f.salt)+8)
+	copy(ad, f.salt)
+	binary.LittleEndian.PutUint64(ad[len(f.salt):], uint64(i))
+
+	return ad
+}
+
+// readBlock returns the content of block i of a file of phys bytes.
+func (f *codecFile) readBlock(i, phys int64) ([]byte, C.int) {
+	off := codecHeaderSize + i*codecPhysBlock
+	n := phys - off
+	if n > codecPhysBlock {
+		n = codecPhysBlock
+	}
+	if n <= codecOverhead {
+		return nil, C.UNQLITE_OK
+	}
+
+	b := make([]byte, n)
+	res := C.codec_real_read(f.real, unsafe.Pointer(&b[0]), C.unqlite_int64(n), C.unqlite_int64(off))
+	if res != C.UNQLITE_OK {
+		return nil, res
+	}
+
+	ad := f.blockAD(i)
+	p, err := unseal(f.aead, b, ad)
+	if err != nil && f.alt != nil {
+		p, err = unseal(f.alt, b, ad)
+	}
+	if err != nil {
+		return nil, C.UNQLITE_CORRUPT
+	}
+
+	return p, C.UNQLITE_OK
+}
+
+// writeBlock seals p as block i.
+func (f *codecFile) writeBlock(i int64, p []byte) C.int {
+	b, err := seal(f.aead, p, f.blockAD(i))
+	if err != nil {
+		return C.UNQLITE_IOERR
+	}
+	off := codecHeaderSize + i*codecPhysBlock
+
+	return C.codec_real_write(f.real, unsafe.Pointer(&b[0]), C.unqlite_int64(len(b)), C.unqlite_int64(off))
+}
+
+// readAt reads len(p) bytes at off, it returns UNQLITE_IOERR on a short read
+// with the unread part of p zero-filled.
+func (f *codecFile) readAt(p []byte, off int64) C.int {
+	phys, res := f.physSize()
+	if res != C.UNQLITE_OK {
+		return res
+	}
+
+	for len(p) > 0 {
+		b, res := f.readBlock(off/codecBlockSize, phys)
+		if res != C.UNQLITE_OK {
+			return res
+		}
+		start := int(off % codecBlockSize)
+		if start >= len(b) {
+			for i := range p {
+				p[i] = 0
+			}
+			return C.UNQLITE_IOERR
+		}
+		n := copy(p, b[start:])
+		p = p[n:]
+		off += int64(n)
+	}
+
+	return C.UNQLITE_OK
+}
+
+// writeAt writes p at off, a gap past the end of the file is zero-filled.
+func (f *codecFile) writeAt(p []byte, off int64) C.int {
+	phys, res := f.physSize()
+	if res != C.UNQLITE_OK {
+		return res
+	}
+	if phys == 0 {
+		// A new or truncated file, start it with a fresh salt
+		f.salt = make([]byte, hdrCheck-hdrSalt)
+		if _, err := rand.Read(f.salt); err != nil {
+			return C.UNQLITE_IOERR
+		}
+		if res = f.writeHeader(nil, nil); res != C.UNQLITE_OK {
+			return res
+		}
+	}
+
+	size, res := f.size()
+	if res != C.UNQLITE_OK {
+		return res
+	}
+	if off > size {
+		p = append(make([]byte, off-size), p...)
+		off = size
+	}
+	if phys, res = f.physSize(); res != C.UNQLITE_OK {
+		return res
+	}
+
+	for len(p) > 0 {
+		i := off / codecBlockSize
+		start := int(off % codecBlockSize)
+
+		var b []byte
+		if i*codecBlockSize < size {
+			if b, res = f.readBlock(i, phys); res != C.UNQLITE_OK {
+				return res
+			}
+		}
+		if end := start + len(p); end > len(b) {
+			if end > codecBlockSize {
+				end = codecBlockSize
+			}
+			b = append(b, make([]byte, end-len(b))...)
+		}
+		n := copy(b[start:], p)
+		if res = f.writeBlock(i, b); res != C.UNQLITE_OK {
+			return res
+		}
+		p = p[n:]
+		off += int64(n)
+	}
+
+	return C.UNQLITE_OK
+}
+
+// truncate changes the size of the content of the file.
+func (f *codecFile) truncate(size int64) C.int {
+	if size == 0 {
+		return C.codec_real_truncate(f.real, 0)
+	}
+
+	cur, res := f.size()
+	if res != C.UNQLITE_OK || size == cur {
+		return res
+	}
+	if size > cur {
+		return f.writeAt(nil, size)
+	}
+
+	i := size / codecBlockSize
+	phys := codecHeaderSize + i*codecPhysBlock
+	if rem := size % codecBlockSize; rem > 0 {
+		cur, res := f.physSize()
+		if res != C.UNQLITE_OK {
+			return res
+		}
+		b, res := f.readBlock(i, cur)
+		if res != C.UNQLITE_OK {
+			return res
+		}
+		if res = f.writeBlock(i, b[:rem]); res != C.UNQLITE_OK {
+			return res
+		}
+		phys += rem + codecOverhead
+	}
+
+	return C.codec_real_truncate(f.real, C.unqlite_int64(phys))
+}
+
+//export goCodecOpen
+func goCodecOpen(handle C.uintptr_t, real *C.unqlite_file, flags C.uint, file *C.uintptr_t) C.int {
+	c := cgo.Handle(handle).Value().(*codec)
+	f := &codecFile{c: c, real: real, aead: c.aead}
+
+	phys, res := f.physSize()
+	if res != C.UNQLITE_OK {
+		return res
+	}
+	if phys > 0 {
+		if phys < codecHeaderSize {
+			return C.UNQLITE_CORRUPT
+		}
+		if res = f.readHeader(flags&C.UNQLITE_OPEN_READWRITE != 0); res != C.UNQLITE_OK {
+			return res
+		}
+	}
+
+	if c.main == nil {
+		c.main = f
+	}
+	*file = C.uintptr_t(cgo.NewHandle(f))
+
+	return C.UNQLITE_OK
+}
+
+//export goCodecClose
+func goCodecClose(handle C.uintptr_t) C.int {
+	h := cgo.Handle(handle)
+	f := h.Value().(*codecFile)
+	if f.c.main == f {
+		f.c.main = nil
+	}
+	h.Delete()
+
+	return C.UNQLITE_OK
+}
+
+//export goCodecRead
+func goCodecRead(handle C.uintptr_t, buf unsafe.Pointer, n, off C.unqlite_int64) C.int {
+	f := cgo.Handle(handle).Value().(*codecFile)
+
+	return f.readAt(unsafe.Slice((*byte)(buf), int(n)), int64(off))
+}
+
+//export goCodecWrite
+func goCodecWrite(handle C.uintptr_t, buf unsafe.Pointer, n, off C.unqlite_int64) C.int {
+	f := cgo.Handle(handle).Value().(*codecFile)
+
+	return f.writeAt(unsafe.Slice((*byte)(buf), int(n)), int64(off))
+}
+
+//export goCodecTruncate
+func goCodecTruncate(handle C.uintptr_t, size C.unqlite_int64) C.int {
+	return cgo.Handle(handle).Value().(*codecFile).truncate(int64(size))
+}
+
+//export goCodecFileSize
+func goCodecFileSize(handle C.uintptr_t, size *C.unqlite_int64) C.int {
+	n, res := cgo.Handle(handle).Value().(*codecFile).size()
+	*size = C.unqlite_int64(n)
+
+	return res
+}
+
+// Rekey re-encrypts the database with newKey. It returns ErrTxOpen while a
+// transaction is open or changes are not yet committed, commit or roll them back
+// first. The file is rewritten in place under an exclusive lock. When Rekey fails
+// the file is rolled back to the current key, when interrupted the next open with
+// either key finishes the file with that key. Rekey must not run concurrently with
+// other operations on the database.
+func (db *Database) Rekey(newKey []byte) error {
+	if db.codec == nil || len(newKey) != codecKeySize {
+		return ErrInvalidConfig
+	}
+
+	select {
+	case db.wlock <- struct{}{}:
+	default:
+		// Waiting for the writable transaction deadlocks when called from it
+		return ErrTxOpen
+	}
+	defer func() { <-db.wlock }()
+
+	if db.codec.inTx() {
+		return ErrTxOpen
+	}
+
+	// The write transaction opens the file and keeps out other writers
+	if res := C.unqlite_begin(db.conn); res != C.UNQLITE_OK {
+		return db.error("Rekey", nil, res)
+	}
+	defer C.unqlite_rollback(db.conn)
+
+	if res := db.codec.rekey(newKey); res != C.UNQLITE_OK {
+		return db.error("Rekey", nil, res)
+	}
+
+	return nil
+}
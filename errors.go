@@ -0,0 +1,103 @@
+package unqlitego
+
+// #include <unqlite.h>
+import "C"
+
+import (
+	"strconv"
+)
+
+// UnQLite native errors, usable as targets for errors.Is.
+const (
+	ErrNoMem          UnQLiteError = C.UNQLITE_NOMEM
+	ErrAbort          UnQLiteError = C.UNQLITE_ABORT
+	ErrIO             UnQLiteError = C.UNQLITE_IOERR
+	ErrCorrupt        UnQLiteError = C.UNQLITE_CORRUPT
+	ErrLocked         UnQLiteError = C.UNQLITE_LOCKED
+	ErrBusy           UnQLiteError = C.UNQLITE_BUSY
+	ErrDone           UnQLiteError = C.UNQLITE_DONE
+	ErrPerm           UnQLiteError = C.UNQLITE_PERM
+	ErrNotImplemented UnQLiteError = C.UNQLITE_NOTIMPLEMENTED
+	ErrNotFound       UnQLiteError = C.UNQLITE_NOTFOUND
+	ErrNoop           UnQLiteError = C.UNQLITE_NOOP
+	ErrInvalid        UnQLiteError = C.UNQLITE_INVALID
+	ErrEOF            UnQLiteError = C.UNQLITE_EOF
+	ErrUnknown        UnQLiteError = C.UNQLITE_UNKNOWN
+	ErrLimit          UnQLiteError = C.UNQLITE_LIMIT
+	ErrExists         UnQLiteError = C.UNQLITE_EXISTS
+	ErrEmpty          UnQLiteError = C.UNQLITE_EMPTY
+	ErrCompile        UnQLiteError = C.UNQLITE_COMPILE_ERR
+	ErrVM             UnQLiteError = C.UNQLITE_VM_ERR
+	ErrFull           UnQLiteError = C.UNQLITE_FULL
+	ErrCantOpen       UnQLiteError = C.UNQLITE_CANTOPEN
+	ErrReadOnly       UnQLiteError = C.UNQLITE_READ_ONLY
+	ErrLockProtocol   UnQLiteError = C.UNQLITE_LOCKERR
+)
+
+// Error is returned by failed database operations. It carries the operation
+// and key involved next to the UnQLite error code and the text the engine
+// wrote to its error log.
+//
+// Error unwraps to its Code, so it can be tested with errors.Is:
+//
+//	if errors.Is(err, unqlitego.ErrNotFound) {
+//		...
+//	}
+type Error struct {
+	// Operation which failed, i.e: Store, Cursor.Seek
+	Op string
+
+	// Key the operation was performed on, if any
+	Key []byte
+
+	// Native or UnQLite Go error code
+	Code UnQLiteError
+
+	// Text of the engine error log written by the operation
+	Log string
+}
+
+// Error returns the string representation of the Error.
+func (e *Error) Error() string {
+	s := e.Op
+	if e.Key != nil {
+		s += " " + strconv.Quote(string(e.Key))
+	}
+	s += ": " + e.Code.Error()
+	if e.Log != "" {
+		s += ": " + e.Log
+	}
+
+	return s
+}
+
+// Unwrap returns the UnQLiteError code of the Error.
+func (e *Error) Unwrap() error {
+	return e.Code
+}
+
+// newError creates an Error for operations without a database handle.
+func newError(op string, key []byte, res C.int) *Error {
+	e := &Error{
+		Op:   op,
+		Code: UnQLiteError(res),
+	}
+	if key != nil {
+		e.Key = append([]byte{}, key...)
+	}
+
+	return e
+}
+
+// error creates an Error which includes the new entries of the database error log.
+func (db *Database) error(op string, key []byte, res C.int) error {
+	e := newError(op, key, res)
+	e.Log = db.newErrLog()
+	if db.codec != nil && db.codec.err != 0 {
+		// The engine only sees an IO error for a wrong key
+		e.Code = db.codec.err
+		db.codec.err = 0
+	}
+
+	return e
+}
@@ -0,0 +1,123 @@
+package unqlitego
+
+// #include <unqlite.h>
+// #include <wrappers.h>
+import "C"
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"runtime/cgo"
+	"strings"
+	"unsafe"
+)
+
+// fsOpenWrite are the JX9 stream open flags requesting write access.
+const fsOpenWrite = 0x002 | 0x004 | 0x008 | 0x010 | 0x020 | 0x040
+
+// CompileFS compiles the JX9 script name read from fsys into a Virtual Machine,
+// for example from an embed.FS. The script reaches fsys through the fs:// stream,
+// as in include 'fs://lib/util.jx9', names without a scheme are files of the
+// operating system. Relative names are searched in the directory of name and the
+// import paths. The file system is read-only for the script.
+// On a compile error the JX9 error log is returned next to the error.
+func (db *Database) CompileFS(fsys fs.FS, name string, vm *VM) (string, error) {
+	src, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return "", err
+	}
+
+	if log, err := db.Compile(string(src), vm); err != nil {
+		return log, err
+	}
+
+	res := C.vm_config_fs_stream(vm.vm)
+	if res != C.UNQLITE_OK {
+		vm.Close()
+		return "", newError("CompileFS", []byte(name), res)
+	}
+	vm.fsys = cgo.NewHandle(fsys)
+
+	if err := vm.AddImportPath(path.Dir(name)); err != nil {
+		vm.Close()
+		return "", err
+	}
+
+	return "", nil
+}
+
+// fsName converts a path of the JX9 stream into a fs.FS name.
+func fsName(p string) string {
+	p = strings.ReplaceAll(p, `\`, "/")
+
+	return path.Clean(strings.TrimLeft(p, "/"))
+}
+
+//export goFSOpen
+func goFSOpen(fsys C.uintptr_t, p *C.char, mode C.int, handle *C.uintptr_t) C.int {
+	if mode&fsOpenWrite != 0 {
+		return C.UNQLITE_READ_ONLY
+	}
+
+	name := fsName(C.GoString(p))
+	if !fs.ValidPath(name) {
+		return C.UNQLITE_NOTFOUND
+	}
+
+	f, err := cgo.Handle(fsys).Value().(fs.FS).Open(name)
+	if err != nil {
+		return C.UNQLITE_IOERR
+	}
+	*handle = C.uintptr_t(cgo.NewHandle(f))
+
+	return C.UNQLITE_OK
+}
+
+//export goFSClose
+func goFSClose(handle C.uintptr_t) {
+	h := cgo.Handle(handle)
+	h.Value().(fs.File).Close()
+	h.Delete()
+}
+
+//export goFSRead
+func goFSRead(handle C.uintptr_t, buf unsafe.Pointer, n C.unqlite_int64) C.unqlite_int64 {
+	f := cgo.Handle(handle).Value().(fs.File)
+
+	read, err := f.Read(unsafe.Slice((*byte)(buf), int(n)))
+	if read == 0 && err != nil && err != io.EOF {
+		return -1
+	}
+
+	return C.unqlite_int64(read)
+}
+
+//export goFSSeek
+func goFSSeek(handle C.uintptr_t, offset C.unqlite_int64, whence C.int) C.int {
+	s, ok := cgo.Handle(handle).Value().(io.Seeker)
+	if !ok {
+		return C.UNQLITE_NOTIMPLEMENTED
+	}
+
+	if _, err := s.Seek(int64(offset), int(whence)); err != nil {
+		return C.UNQLITE_IOERR
+	}
+
+	return C.UNQLITE_OK
+}
+
+//export goFSTell
+func goFSTell(handle C.uintptr_t) C.unqlite_int64 {
+	s, ok := cgo.Handle(handle).Value().(io.Seeker)
+	if !ok {
+		return -1
+	}
+
+	off, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+
+	return C.unqlite_int64(off)
+}
@@ -0,0 +1,293 @@
+package unqlitego
+
+// #include <unqlite.h>
+// #include <wrappers.h>
+// #include <stdlib.h>
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"runtime/cgo"
+	"strings"
+	"unsafe"
+)
+
+// Function is a Go function callable from JX9 scripts.
+//
+// The returned Value becomes the result of the call, unless it is the zero Value
+// in which case the result set through the FuncContext (null by default) is kept.
+// A returned error is thrown as a JX9 error and the call evaluates to null, an
+// error wrapping ErrAbort aborts the script instead. A panic aborts the script as
+// well, Run and ExecuteContext return a *CallbackError for both.
+type Function func(ctx *FuncContext, args []Value) (Value, error)
+
+// CallbackError is returned by Run and ExecuteContext when a Go function called by
+// the script aborted it, with a panic or an error wrapping ErrAbort.
+// It unwraps to the *Error of the execution, and so to ErrAborted, and to Err.
+type CallbackError struct {
+	// Name of the function
+	Name string
+
+	// Error returned by the function, or the recovered panic
+	Err error
+
+	// Underlying error
+	err *Error
+}
+
+// Error returns the message of the callback error.
+func (e *CallbackError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the *Error of the execution and the error of the function.
+func (e *CallbackError) Unwrap() []error {
+	return []error{e.err, e.Err}
+}
+
+// callbackState records the first failure of the Go callbacks of a VM during an
+// execution. The handles of the callbacks refer to it rather than to the VM, so
+// the finalizer of an unreachable VM still runs.
+type callbackState struct {
+	err *CallbackError
+}
+
+// fail records the failure err of the callback name, unless one is recorded already.
+func (s *callbackState) fail(name string, err error) {
+	if s.err != nil {
+		return
+	}
+
+	s.err = &CallbackError{
+		Name: name,
+		Err:  err,
+		err:  &Error{Op: "Execute", Code: ErrAborted, Log: name + ": " + err.Error()},
+	}
+}
+
+// panicError converts a recovered panic into an error.
+func panicError(r interface{}) error {
+	if err, ok := r.(error); ok {
+		return fmt.Errorf("panic: %w", err)
+	}
+
+	return fmt.Errorf("panic: %v", r)
+}
+
+// foreignFunc is the value of the handle of a registered foreign function.
+type foreignFunc struct {
+	fn    Function
+	state *callbackState
+}
+
+// FuncContext represents the call context of a foreign function.
+// It is only valid until the function returns.
+type FuncContext struct {
+	ctx *C.unqlite_context
+}
+
+// RegisterFunc registers fn as the foreign function name of the compiled script.
+// Registering a name again replaces the previous function.
+func (vm *VM) RegisterFunc(name string, fn Function) error {
+	name = strings.TrimSpace(name)
+	if name == "" || fn == nil {
+		return ErrInvalid
+	}
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	h := cgo.NewHandle(&foreignFunc{fn: fn, state: vm.callbackState()})
+	res := C.vm_create_function(vm.vm, cname, C.uintptr_t(h))
+	if res != C.UNQLITE_OK {
+		h.Delete()
+		return newError("RegisterFunc", []byte(name), res)
+	}
+
+	if vm.funcs == nil {
+		vm.funcs = make(map[string]cgo.Handle)
+	}
+	if old, ok := vm.funcs[name]; ok {
+		old.Delete()
+	}
+	vm.funcs[name] = h
+
+	return nil
+}
+
+// UnregisterFunc removes the foreign function name from the compiled script.
+func (vm *VM) UnregisterFunc(name string) error {
+	name = strings.TrimSpace(name)
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	res := C.unqlite_delete_function(vm.vm, cname)
+	if res != C.UNQLITE_OK {
+		return newError("UnregisterFunc", []byte(name), res)
+	}
+
+	if h, ok := vm.funcs[name]; ok {
+		h.Delete()
+		delete(vm.funcs, name)
+	}
+
+	return nil
+}
+
+// callbackState returns the failure record shared by the callbacks of the VM.
+func (vm *VM) callbackState() *callbackState {
+	if vm.state == nil {
+		vm.state = &callbackState{}
+	}
+
+	return vm.state
+}
+
+// releaseFuncs deletes the handles of the registered foreign functions.
+func (vm *VM) releaseFuncs() {
+	for name, h := range vm.funcs {
+		h.Delete()
+		delete(vm.funcs, name)
+	}
+}
+
+//export goForeignFunction
+func goForeignFunction(ctx *C.unqlite_context, argc C.int, argv **C.unqlite_value, handle C.uintptr_t) (rc C.int) {
+	f := cgo.Handle(handle).Value().(*foreignFunc)
+	c := &FuncContext{ctx: ctx}
+
+	// A panic must not unwind through the VM.
+	defer func() {
+		if r := recover(); r != nil {
+			c.ThrowError(fmt.Sprint(r))
+			f.state.fail(c.FunctionName(), panicError(r))
+			rc = C.UNQLITE_ABORT
+		}
+	}()
+
+	args := make([]Value, int(argc))
+	for i, v := range unsafe.Slice(argv, int(argc)) {
+		args[i] = Value{v: v}
+	}
+
+	ret, err := f.fn(c, args)
+	if err != nil {
+		c.ThrowError(err.Error())
+		if errors.Is(err, ErrAbort) {
+			f.state.fail(c.FunctionName(), err)
+			return C.UNQLITE_ABORT
+		}
+		c.ResultNull()
+		return C.UNQLITE_OK
+	}
+
+	if ret.v != nil {
+		c.ResultValue(ret)
+	}
+
+	return C.UNQLITE_OK
+}
+
+// FunctionName returns the name of the called foreign function.
+func (c *FuncContext) FunctionName() string {
+	return C.GoString(C.unqlite_function_name(c.ctx))
+}
+
+// NewValue creates a value from a Go value, converted as by VM.SetVar.
+// The value is released when the foreign function returns.
+func (c *FuncContext) NewValue(x interface{}) (Value, error) {
+	v, err := newValue(contextAllocator{ctx: c.ctx}, x)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return Value{v: v}, nil
+}
+
+// Result sets the result of the call from a Value or a Go scalar.
+func (c *FuncContext) Result(x interface{}) error {
+	if v, ok := x.(Value); ok {
+		return c.ResultValue(v)
+	}
+
+	v, err := c.NewValue(x)
+	if err != nil {
+		return err
+	}
+	defer C.unqlite_context_release_value(c.ctx, v.v)
+
+	return c.ResultValue(v)
+}
+
+// ResultValue sets the result of the call to a copy of v.
+func (c *FuncContext) ResultValue(v Value) error {
+	if v.v == nil {
+		return c.ResultNull()
+	}
+
+	return resultError(C.unqlite_result_value(c.ctx, v.v))
+}
+
+// ResultNull sets the result of the call to null.
+func (c *FuncContext) ResultNull() error {
+	return resultError(C.unqlite_result_null(c.ctx))
+}
+
+// ResultInt64 sets the result of the call to an integer.
+func (c *FuncContext) ResultInt64(i int64) error {
+	return resultError(C.unqlite_result_int64(c.ctx, C.unqlite_int64(i)))
+}
+
+// ResultFloat64 sets the result of the call to a float.
+func (c *FuncContext) ResultFloat64(f float64) error {
+	return resultError(C.unqlite_result_double(c.ctx, C.double(f)))
+}
+
+// ResultBool sets the result of the call to a boolean.
+func (c *FuncContext) ResultBool(b bool) error {
+	var i C.int
+	if b {
+		i = 1
+	}
+
+	return resultError(C.unqlite_result_bool(c.ctx, i))
+}
+
+// ResultString sets the result of the call to a string.
+func (c *FuncContext) ResultString(s string) error {
+	return c.Result(s)
+}
+
+// ThrowError throws a JX9 error from the foreign function.
+func (c *FuncContext) ThrowError(msg string) {
+	c.throw(C.UNQLITE_CTX_ERR, msg)
+}
+
+// ThrowWarning throws a JX9 warning from the foreign function.
+func (c *FuncContext) ThrowWarning(msg string) {
+	c.throw(C.UNQLITE_CTX_WARNING, msg)
+}
+
+// ThrowNotice throws a JX9 notice from the foreign function.
+func (c *FuncContext) ThrowNotice(msg string) {
+	c.throw(C.UNQLITE_CTX_NOTICE, msg)
+}
+
+// throw reports msg with the given severity through the VM error consumer.
+func (c *FuncContext) throw(severity C.int, msg string) {
+	cmsg := C.CString(msg)
+	defer C.free(unsafe.Pointer(cmsg))
+
+	C.unqlite_context_throw_error(c.ctx, severity, cmsg)
+}
+
+// resultError converts the return code of a result setter.
+func resultError(res C.int) error {
+	if res != C.UNQLITE_OK {
+		return UnQLiteError(res)
+	}
+
+	return nil
+}
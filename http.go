@@ -0,0 +1,163 @@
+package unqlitego
+
+// #include <unqlite.h>
+// #include <wrappers.h>
+// #include <stdlib.h>
+import "C"
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// SetHTTPRequest makes r the request processed by the script. $_GET, $_POST,
+// $_REQUEST, $_COOKIE, $_HEADER and the request attributes of $_SERVER are filled
+// from it, replacing the data of a previous request.
+//
+// As in PHP, the query string fills $_GET for every method but $_POST is only
+// filled by the url encoded form body of a POST request. The engine names the
+// GET, POST, PUT and HEAD methods only, REQUEST_METHOD is set to r.Method after
+// the request is processed. The body of r is read and replaced, so it can still
+// be read afterwards.
+func (vm *VM) SetHTTPRequest(r *http.Request) error {
+	raws, err := rawRequest(r)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range requestVars {
+		if err := vm.SetVar(name, map[string]interface{}{}); err != nil {
+			return err
+		}
+	}
+
+	for _, raw := range raws {
+		craw := C.CBytes(raw)
+		res := C.vm_config_http_request(vm.vm, (*C.char)(craw), C.int(len(raw)))
+		C.free(craw)
+		if res != C.UNQLITE_OK {
+			return newError("SetHTTPRequest", nil, res)
+		}
+	}
+
+	if r.Method != "" {
+		if err := vm.SetServerAttr("REQUEST_METHOD", r.Method); err != nil {
+			return err
+		}
+	}
+	if r.RemoteAddr != "" {
+		return vm.SetServerAttr("REMOTE_ADDR", r.RemoteAddr)
+	}
+
+	return nil
+}
+
+// Superglobals filled from the request, the engine keeps them between requests.
+var requestVars = []string{"_GET", "_POST", "_REQUEST", "_COOKIE", "_HEADER"}
+
+// SetServerAttr sets the entry key of $_SERVER.
+func (vm *VM) SetServerAttr(key, value string) error {
+	return vm.setAttr("SetServerAttr", key, value, false)
+}
+
+// SetEnv sets the entry key of $_ENV.
+func (vm *VM) SetEnv(key, value string) error {
+	return vm.setAttr("SetEnv", key, value, true)
+}
+
+// setAttr sets the entry key of $_SERVER, or of $_ENV.
+func (vm *VM) setAttr(op, key, value string, env bool) error {
+	if key == "" {
+		return ErrInvalid
+	}
+
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+	cvalue := C.CString(value)
+	defer C.free(unsafe.Pointer(cvalue))
+
+	var res C.int
+	if env {
+		res = C.vm_config_env_attr(vm.vm, ckey, cvalue, C.int(len(value)))
+	} else {
+		res = C.vm_config_server_attr(vm.vm, ckey, cvalue, C.int(len(value)))
+	}
+	if res != C.UNQLITE_OK {
+		return newError(op, []byte(key), res)
+	}
+
+	return nil
+}
+
+// rawRequest serializes r in the HTTP/1.x wire format parsed by the engine.
+// The body is sent with its length, never chunked. The engine only decodes the
+// query string of a GET request, so a request of another method with a query
+// is preceded by a GET request of its URI.
+func rawRequest(r *http.Request) ([][]byte, error) {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		b, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	uri := r.RequestURI
+	if r.URL != nil {
+		uri = r.URL.RequestURI()
+	}
+
+	host := r.Host
+	if host == "" && r.URL != nil {
+		host = r.URL.Host
+	}
+
+	var raws [][]byte
+	if method != http.MethodGet && strings.Contains(uri, "?") {
+		// The engine needs a header after the request line
+		raws = append(raws, []byte(fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\n\r\n", uri, host)))
+	}
+
+	proto := "HTTP/1.1"
+	if r.ProtoMajor == 1 && r.ProtoMinor == 0 {
+		proto = "HTTP/1.0"
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s %s\r\n", method, uri, proto)
+
+	if host != "" {
+		fmt.Fprintf(&buf, "Host: %s\r\n", host)
+	}
+
+	h := r.Header.Clone()
+	if h == nil {
+		h = make(http.Header)
+	}
+	h.Del("Host")
+	h.Del("Transfer-Encoding")
+	h.Del("Content-Length")
+	if len(body) > 0 {
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	if err := h.Write(&buf); err != nil {
+		return nil, err
+	}
+
+	buf.WriteString("\r\n")
+	buf.Write(body)
+
+	return append(raws, buf.Bytes()), nil
+}
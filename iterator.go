@@ -0,0 +1,255 @@
+package unqlitego
+
+import (
+	"bytes"
+	"errors"
+)
+
+// IteratorOptions configures the walk of an Iterator.
+type IteratorOptions struct {
+	// Reverse walks the cursor from the last to the first entry.
+	Reverse bool
+
+	// KeysOnly skips reading the values, Value returns nil.
+	KeysOnly bool
+
+	// Offset is the number of matching entries to skip.
+	Offset int
+
+	// Limit is the maximum number of entries to return, zero means no limit.
+	Limit int
+}
+
+// Iterator walks the entries of a database within a key range.
+//
+// The entries are returned in the order of the underlying Key/Value engine.
+// The builtin engines (hash, mem) do not keep keys ordered, so every entry
+// is visited and compared bytewise against the range bounds. Over an
+// OrderedKVEngine the bounds are compared with its Compare instead, the walk
+// seeks to the first entry of the range and ends after its last.
+//
+//	it, err := db.Prefix([]byte("user:"))
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		fmt.Println(string(it.Key()), string(it.Value()))
+//	}
+//	return it.Err()
+type Iterator struct {
+	// Database Cursor
+	cr *Cursor
+
+	// Range bounds, [start, end). nil is unbounded.
+	start, end []byte
+
+	// Prefix of the keys, nil for any key
+	prefix []byte
+
+	// Key order of the range bounds
+	cmp func(a, b []byte) int
+
+	// Engine keeps the entries in the order of cmp
+	ordered bool
+
+	// Walk Options
+	opts IteratorOptions
+
+	// Current entry
+	key, value []byte
+
+	// Cursor positioned on the first entry
+	started bool
+
+	// Matching entries skipped and returned
+	skipped, count int
+
+	// Walk finished
+	done bool
+
+	// First error encountered
+	err error
+}
+
+// Range returns an Iterator over the entries with start <= key < end.
+// A nil start or end leaves the range unbounded on that side, nil opts uses the defaults.
+func (db *Database) Range(start, end []byte, opts *IteratorOptions) (*Iterator, error) {
+	cr, err := db.Cursor()
+	if err != nil {
+		return nil, err
+	}
+
+	it := &Iterator{
+		cr:    cr,
+		start: start,
+		end:   end,
+		cmp:   cr.order(),
+	}
+	if it.cmp != nil {
+		it.ordered = true
+	} else {
+		it.cmp = bytes.Compare
+	}
+	if opts != nil {
+		it.opts = *opts
+	}
+
+	return it, nil
+}
+
+// Prefix returns an Iterator over the entries whose key starts with p.
+//
+// Over an OrderedKVEngine the walk is limited to the keys from p up to the first
+// key past every key starting with p, as they are in bytewise order. When the
+// engine orders p after that key, i.e. with ReverseComparator, every entry is visited.
+func (db *Database) Prefix(p []byte) (*Iterator, error) {
+	it, err := db.Range(p, prefixEnd(p), nil)
+	if err != nil {
+		return nil, err
+	}
+	it.prefix = p
+
+	if it.ordered && it.end != nil && it.cmp(it.start, it.end) >= 0 {
+		it.start, it.end, it.ordered = nil, nil, false
+	}
+
+	return it, nil
+}
+
+// prefixEnd returns the smallest key larger than every key starting with p,
+// or nil when there is no such key.
+func prefixEnd(p []byte) []byte {
+	end := append([]byte{}, p...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
+
+// contains returns a boolean indicating if the key is within the range.
+func (it *Iterator) contains(key []byte) bool {
+	if it.start != nil && it.cmp(key, it.start) < 0 {
+		return false
+	}
+	if it.end != nil && it.cmp(key, it.end) >= 0 {
+		return false
+	}
+
+	return it.prefix == nil || bytes.HasPrefix(key, it.prefix)
+}
+
+// past returns a boolean indicating if the ordered walk went past the range.
+func (it *Iterator) past(key []byte) bool {
+	if it.opts.Reverse {
+		return it.start != nil && it.cmp(key, it.start) < 0
+	}
+
+	return it.end != nil && it.cmp(key, it.end) >= 0
+}
+
+// move positions the cursor on the next entry to examine.
+func (it *Iterator) move() error {
+	if !it.started {
+		it.started = true
+		switch {
+		case it.ordered && it.opts.Reverse && it.end != nil:
+			return it.cr.SeekLE(it.end)
+		case it.ordered && !it.opts.Reverse && it.start != nil:
+			return it.cr.SeekGE(it.start)
+		case it.opts.Reverse:
+			return it.cr.Last()
+		}
+		return it.cr.First()
+	}
+
+	if it.opts.Reverse {
+		return it.cr.Prev()
+	}
+	return it.cr.Next()
+}
+
+// Next advances the Iterator to the next entry within the range.
+// It returns false when the walk is finished or an error occurred.
+func (it *Iterator) Next() bool {
+	if it.done {
+		return false
+	}
+	if it.opts.Limit > 0 && it.count >= it.opts.Limit {
+		return it.finish(nil)
+	}
+
+	for {
+		if err := it.move(); err != nil {
+			if errors.Is(err, ErrDone) || errors.Is(err, ErrEOF) || errors.Is(err, ErrNotFound) {
+				err = nil
+			}
+			return it.finish(err)
+		}
+		if !it.cr.IsValid() {
+			return it.finish(nil)
+		}
+
+		key, err := it.cr.Key()
+		if err != nil {
+			return it.finish(err)
+		}
+		if !it.contains(key) {
+			if it.ordered && it.past(key) {
+				return it.finish(nil)
+			}
+			continue
+		}
+		if it.skipped < it.opts.Offset {
+			it.skipped++
+			continue
+		}
+
+		var value []byte
+		if !it.opts.KeysOnly {
+			if value, err = it.cr.Value(); err != nil {
+				return it.finish(err)
+			}
+		}
+
+		it.key, it.value = key, value
+		it.count++
+
+		return true
+	}
+}
+
+// finish ends the walk recording err.
+func (it *Iterator) finish(err error) bool {
+	it.done = true
+	it.err = err
+	it.key, it.value = nil, nil
+
+	return false
+}
+
+// Key returns the key of the current entry.
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value returns the value of the current entry, nil with KeysOnly.
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Err returns the error which ended the walk, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the cursor of the Iterator.
+func (it *Iterator) Close() error {
+	it.done = true
+
+	return it.cr.Close()
+}
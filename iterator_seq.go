@@ -0,0 +1,71 @@
+//go:build go1.23
+// +build go1.23
+
+package unqlitego
+
+import (
+	"iter"
+)
+
+// All returns a sequence of the remaining entries of the Iterator for use with range.
+// The Iterator is closed once the sequence ends, check Err afterwards.
+func (it *Iterator) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		defer it.Close()
+
+		for it.Next() {
+			if !yield(it.Key(), it.Value()) {
+				return
+			}
+		}
+	}
+}
+
+// All returns a sequence of every entry of the database for use with range, and
+// a function returning the error which ended the last walk over it, if any.
+//
+//	entries, errf := db.All()
+//	for k, v := range entries {
+//		fmt.Println(string(k), string(v))
+//	}
+//	if err := errf(); err != nil {
+//		return err
+//	}
+func (db *Database) All() (iter.Seq2[[]byte, []byte], func() error) {
+	return db.seq(func() (*Iterator, error) {
+		return db.Range(nil, nil, nil)
+	})
+}
+
+// RangeAll returns a sequence of the entries with start <= key < end for use with range,
+// and a function returning the error which ended the last walk over it.
+func (db *Database) RangeAll(start, end []byte) (iter.Seq2[[]byte, []byte], func() error) {
+	return db.seq(func() (*Iterator, error) {
+		return db.Range(start, end, nil)
+	})
+}
+
+// PrefixAll returns a sequence of the entries whose key starts with p for use with range,
+// and a function returning the error which ended the last walk over it.
+func (db *Database) PrefixAll(p []byte) (iter.Seq2[[]byte, []byte], func() error) {
+	return db.seq(func() (*Iterator, error) {
+		return db.Prefix(p)
+	})
+}
+
+// seq returns a sequence over a new Iterator for each walk, and its error accessor.
+func (db *Database) seq(newIterator func() (*Iterator, error)) (iter.Seq2[[]byte, []byte], func() error) {
+	var err error
+
+	seq := func(yield func([]byte, []byte) bool) {
+		var it *Iterator
+		if it, err = newIterator(); err != nil {
+			return
+		}
+
+		it.All()(yield)
+		err = it.Err()
+	}
+
+	return seq, func() error { return err }
+}
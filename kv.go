@@ -0,0 +1,243 @@
+package unqlitego
+
+// #include <unqlite.h>
+// #include <wrappers.h>
+import "C"
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"unsafe"
+)
+
+// Comparator compares two keys and returns an integer comparing a and b:
+// 0 if a == b, -1 if a < b, and +1 if a > b.
+// The slices point into engine memory and must not be retained.
+//
+// The built-in Key/Value engines (hash, mem) only call the Comparator to test keys
+// of equal length for equality, it has no effect on the order of their records nor
+// on Cursor.SeekGE and Cursor.SeekLE. Use NewOrderedKVEngine for ordered records.
+type Comparator func(a, b []byte) int
+
+// HashFunc returns the hash of a key.
+// The slice points into engine memory and must not be retained.
+//
+// A database file records the hash function it was created with and must always
+// be opened with the same HashFunc.
+type HashFunc func(key []byte) uint32
+
+// BytewiseComparator orders keys bytewise.
+func BytewiseComparator(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+// ReverseComparator orders keys bytewise in reverse order.
+func ReverseComparator(a, b []byte) int {
+	return bytes.Compare(b, a)
+}
+
+// Int64BigEndianComparator orders 8 byte keys as big-endian signed int64.
+// Keys of any other length sort after them, bytewise.
+func Int64BigEndianComparator(a, b []byte) int {
+	switch {
+	case len(a) != 8 && len(b) != 8:
+		return bytes.Compare(a, b)
+	case len(a) != 8:
+		return 1
+	case len(b) != 8:
+		return -1
+	}
+
+	x, y := int64(binary.BigEndian.Uint64(a)), int64(binary.BigEndian.Uint64(b))
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+
+	return 0
+}
+
+// CaseInsensitiveComparator orders keys bytewise ignoring ASCII case.
+// It must be combined with CaseInsensitiveHash for lookups to match.
+func CaseInsensitiveComparator(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		x, y := lower(a[i]), lower(b[i])
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+
+	return 0
+}
+
+// CaseInsensitiveHash hashes keys ignoring ASCII case (FNV-1a).
+func CaseInsensitiveHash(key []byte) uint32 {
+	h := uint32(2166136261)
+	for _, c := range key {
+		h ^= uint32(lower(c))
+		h *= 16777619
+	}
+
+	return h
+}
+
+// lower returns the ASCII lower case of c.
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+
+	return c
+}
+
+// kvFuncs holds the Go functions of a Key/Value slot, it is replaced rather than modified.
+type kvFuncs struct {
+	cmp  Comparator
+	hash HashFunc
+}
+
+// kvSlots maps the C trampolines of wrappers.c to the Go functions of each database.
+var kvSlots struct {
+	sync.RWMutex
+	funcs [C.KV_SLOTS]*kvFuncs
+}
+
+// kvSlot returns the slot of the database, allocating one on first use.
+func (db *Database) kvSlot() (int, error) {
+	if db.slot >= 0 {
+		return db.slot, nil
+	}
+
+	kvSlots.Lock()
+	defer kvSlots.Unlock()
+
+	for i, f := range kvSlots.funcs {
+		if f == nil {
+			kvSlots.funcs[i] = &kvFuncs{}
+			db.slot = i
+			return i, nil
+		}
+	}
+
+	return -1, ErrKVSlots
+}
+
+// releaseKVSlot frees the slot of the database.
+func (db *Database) releaseKVSlot() {
+	if db.slot < 0 {
+		return
+	}
+
+	kvSlots.Lock()
+	kvSlots.funcs[db.slot] = nil
+	kvSlots.Unlock()
+
+	db.slot = -1
+}
+
+// SetComparator registers the key comparison function of the underlying Key/Value engine.
+//
+// Up to 16 open databases can register Key/Value functions at once, ErrKVSlots is
+// returned beyond that. The slot of a database is released when it is closed.
+func (db *Database) SetComparator(cmp Comparator) error {
+	if cmp == nil {
+		return ErrInvalidConfig
+	}
+
+	slot, err := db.kvSlot()
+	if err != nil {
+		return err
+	}
+
+	kvSlots.Lock()
+	kvSlots.funcs[slot] = &kvFuncs{cmp: cmp, hash: kvSlots.funcs[slot].hash}
+	kvSlots.Unlock()
+
+	res := C.kv_config_cmp_func(db.conn, C.int(slot))
+	if res != C.UNQLITE_OK {
+		return db.error("SetComparator", nil, res)
+	}
+
+	return nil
+}
+
+// SetHashFunc registers the hash function of the underlying Key/Value engine.
+// The hash function can only be changed while the database is empty.
+// It shares the slot limit of SetComparator.
+func (db *Database) SetHashFunc(hash HashFunc) error {
+	if hash == nil {
+		return ErrInvalidConfig
+	}
+
+	slot, err := db.kvSlot()
+	if err != nil {
+		return err
+	}
+
+	kvSlots.Lock()
+	kvSlots.funcs[slot] = &kvFuncs{cmp: kvSlots.funcs[slot].cmp, hash: hash}
+	kvSlots.Unlock()
+
+	res := C.kv_config_hash_func(db.conn, C.int(slot))
+	if res != C.UNQLITE_OK {
+		return db.error("SetHashFunc", nil, res)
+	}
+
+	return nil
+}
+
+// WithComparator registers the Comparator right after the database is opened.
+func WithComparator(cmp Comparator) Option {
+	return func(o *options) {
+		o.cmp = cmp
+	}
+}
+
+// WithHashFunc registers the HashFunc right after the database is opened.
+func WithHashFunc(hash HashFunc) Option {
+	return func(o *options) {
+		o.hash = hash
+	}
+}
+
+//export goKVCompare
+func goKVCompare(slot C.int, a, b unsafe.Pointer, n C.uint) C.int {
+	kvSlots.RLock()
+	f := kvSlots.funcs[slot]
+	kvSlots.RUnlock()
+
+	x := unsafe.Slice((*byte)(a), int(n))
+	y := unsafe.Slice((*byte)(b), int(n))
+	if f == nil || f.cmp == nil {
+		return C.int(bytes.Compare(x, y))
+	}
+
+	return C.int(f.cmp(x, y))
+}
+
+//export goKVHash
+func goKVHash(slot C.int, key unsafe.Pointer, n C.uint) C.uint {
+	kvSlots.RLock()
+	f := kvSlots.funcs[slot]
+	kvSlots.RUnlock()
+
+	if f == nil || f.hash == nil {
+		// Slot released, never reached while the database is open.
+		return 0
+	}
+
+	return C.uint(f.hash(unsafe.Slice((*byte)(key), int(n))))
+}
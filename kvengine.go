@@ -0,0 +1,361 @@
+package unqlitego
+
+// #include <unqlite.h>
+// #include <wrappers.h>
+// #include <stdlib.h>
+import "C"
+
+import (
+	"errors"
+	"runtime/cgo"
+	"strings"
+	"unsafe"
+)
+
+// SeekMatch is the position a KVCursor seeks to.
+type SeekMatch int
+
+// Seek positions, see the UNQLITE_CURSOR_MATCH_* flags of unqlite.h.
+const (
+	// SeekExact positions the cursor on the key.
+	SeekExact SeekMatch = C.UNQLITE_CURSOR_MATCH_EXACT
+
+	// SeekLE positions the cursor on the largest key smaller than or equal to the key.
+	SeekLE SeekMatch = C.UNQLITE_CURSOR_MATCH_LE
+
+	// SeekGE positions the cursor on the smallest key greater than or equal to the key.
+	SeekGE SeekMatch = C.UNQLITE_CURSOR_MATCH_GE
+)
+
+// KVEngine is a Key/Value storage engine implemented in Go, registered with
+// Library.RegisterKVEngine. Each database using the engine gets its own KVEngine.
+//
+// The engine keeps its records itself, it is not handed the pages of the database
+// file. Begin, Commit and Rollback do not reach the engine, durability and
+// atomicity are up to its implementation.
+//
+// Methods may return an UnQLiteError to control the code reported to the engine,
+// i.e: ErrNotFound from KVCursor.Seek when there is no such record.
+// The text of any other error is written to the error log of the database and
+// ErrIO is reported.
+type KVEngine interface {
+	// Init is called once the engine is attached to a database, with its page size.
+	Init(pageSize int) error
+
+	// Open is called when the pager opens the database file, with its size in pages.
+	// It is not called for in-memory databases.
+	Open(pages int64) error
+
+	// Replace stores value under key, replacing any existing record.
+	// The engine must copy key and value to retain them.
+	Replace(key, value []byte) error
+
+	// Append appends value to the record key, creating it when it does not exist.
+	// The engine must copy key and value to retain them.
+	Append(key, value []byte) error
+
+	// Cursor creates a cursor positioned on the first record.
+	Cursor() KVCursor
+
+	// Release is called when the engine is detached from the database.
+	Release()
+}
+
+// KVCursor is a cursor of a KVEngine. Fetches and deletes of the database are
+// performed with a cursor as well.
+type KVCursor interface {
+	// Seek positions the cursor on key according to match.
+	// ErrNotFound is returned when there is no such record.
+	Seek(key []byte, match SeekMatch) error
+
+	// First positions the cursor on the first record.
+	First() error
+
+	// Last positions the cursor on the last record.
+	Last() error
+
+	// Next moves the cursor to the next record, or past the last one.
+	Next() error
+
+	// Prev moves the cursor to the previous record, or before the first one.
+	Prev() error
+
+	// Valid reports whether the cursor is positioned on a record.
+	Valid() bool
+
+	// Key returns the key of the record, the slice is not modified by the caller.
+	Key() ([]byte, error)
+
+	// Value returns the value of the record, the slice is not modified by the caller.
+	Value() ([]byte, error)
+
+	// Delete removes the record and moves the cursor to the next one.
+	Delete() error
+
+	// Reset positions the cursor on the first record.
+	Reset()
+
+	// Close releases the cursor.
+	Close()
+}
+
+// OrderedKVEngine is a KVEngine keeping its records in the order of Compare.
+// Its cursors honor SeekGE and SeekLE, and an Iterator over a database using it
+// seeks to the start of its range instead of visiting every record.
+type OrderedKVEngine interface {
+	KVEngine
+
+	// Compare orders two keys, see Comparator.
+	Compare(a, b []byte) int
+}
+
+// builtinKVEngines are the names of the storage engines built into the library.
+var builtinKVEngines = []string{"mem", "hash"}
+
+// kvEngine is a Go storage engine registered with the library.
+type kvEngine struct {
+	name    string
+	methods *C.unqlite_kv_methods
+
+	// Handle of the engine constructor
+	handle cgo.Handle
+}
+
+// RegisterKVEngine installs the Go Key/Value storage engine name, databases
+// select it with SetKVEngine or Config.KVEngine. newEngine creates the engine
+// of each database using it.
+//
+// An engine registered before the library is initialized is installed when it is,
+// so Configure and SetVFS can still be used. Registered engines are kept across
+// Shutdown, register them before opening the databases using them. A name already
+// in use, including the built-in "mem" and "hash", returns ErrExists. Names are
+// case insensitive.
+func (l *Library) RegisterKVEngine(name string, newEngine func() KVEngine) error {
+	if name == "" || newEngine == nil {
+		return ErrInvalidConfig
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.kvEngineExists(name) {
+		return newError("RegisterKVEngine", nil, C.UNQLITE_EXISTS)
+	}
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	e := &kvEngine{name: name, handle: cgo.NewHandle(newEngine)}
+	e.methods = C.go_kv_methods_new(cname, C.uintptr_t(e.handle))
+	if e.methods == nil {
+		e.handle.Delete()
+		return newError("RegisterKVEngine", nil, C.UNQLITE_NOMEM)
+	}
+
+	if l.init {
+		res := C.lib_config_kv_engine(e.methods)
+		if res != C.UNQLITE_OK {
+			C.free(unsafe.Pointer(e.methods))
+			e.handle.Delete()
+			return newError("RegisterKVEngine", nil, res)
+		}
+	}
+	l.engines = append(l.engines, e)
+
+	return nil
+}
+
+// kvEngineExists reports whether a storage engine named name is built in or registered.
+func (l *Library) kvEngineExists(name string) bool {
+	for _, n := range builtinKVEngines {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	for _, e := range l.engines {
+		if strings.EqualFold(e.name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// order returns the Compare function of the OrderedKVEngine of the cursor,
+// or nil when the engine does not keep its records ordered.
+func (cr *Cursor) order() func(a, b []byte) int {
+	h := C.kv_cursor_engine(cr.handle)
+	if h == 0 {
+		return nil
+	}
+
+	if e, ok := cgo.Handle(h).Value().(OrderedKVEngine); ok {
+		return e.Compare
+	}
+
+	return nil
+}
+
+// kvResult converts an error of a KVEngine or KVCursor into the code reported to the engine.
+func kvResult(engine *C.unqlite_kv_engine, err error) C.int {
+	if err == nil {
+		return C.UNQLITE_OK
+	}
+
+	var code UnQLiteError
+	if errors.As(err, &code) && code < 0 {
+		return C.int(code)
+	}
+
+	msg := C.CString(err.Error())
+	defer C.free(unsafe.Pointer(msg))
+	C.kv_engine_err(engine, msg)
+
+	return C.UNQLITE_IOERR
+}
+
+//export goKVInit
+func goKVInit(methods C.uintptr_t, engine *C.unqlite_kv_engine, pageSize C.int, handle *C.uintptr_t) C.int {
+	e := cgo.Handle(methods).Value().(func() KVEngine)()
+	if err := e.Init(int(pageSize)); err != nil {
+		return kvResult(engine, err)
+	}
+	*handle = C.uintptr_t(cgo.NewHandle(e))
+
+	return C.UNQLITE_OK
+}
+
+//export goKVRelease
+func goKVRelease(handle C.uintptr_t) {
+	if handle == 0 {
+		// Init failed
+		return
+	}
+
+	h := cgo.Handle(handle)
+	defer h.Delete()
+
+	h.Value().(KVEngine).Release()
+}
+
+//export goKVOpen
+func goKVOpen(handle C.uintptr_t, engine *C.unqlite_kv_engine, pages C.unqlite_int64) C.int {
+	return kvResult(engine, cgo.Handle(handle).Value().(KVEngine).Open(int64(pages)))
+}
+
+//export goKVReplace
+func goKVReplace(handle C.uintptr_t, engine *C.unqlite_kv_engine, key unsafe.Pointer, nKey C.int, data unsafe.Pointer, nData C.unqlite_int64, appendData C.int) C.int {
+	e := cgo.Handle(handle).Value().(KVEngine)
+	k := unsafe.Slice((*byte)(key), int(nKey))
+	v := unsafe.Slice((*byte)(data), int(nData))
+
+	if appendData != 0 {
+		return kvResult(engine, e.Append(k, v))
+	}
+
+	return kvResult(engine, e.Replace(k, v))
+}
+
+//export goKVCursorInit
+func goKVCursorInit(handle C.uintptr_t) C.uintptr_t {
+	return C.uintptr_t(cgo.NewHandle(cgo.Handle(handle).Value().(KVEngine).Cursor()))
+}
+
+//export goKVCursorRelease
+func goKVCursorRelease(handle C.uintptr_t) {
+	h := cgo.Handle(handle)
+	defer h.Delete()
+
+	h.Value().(KVCursor).Close()
+}
+
+//export goKVCursorSeek
+func goKVCursorSeek(handle C.uintptr_t, engine *C.unqlite_kv_engine, key unsafe.Pointer, n C.int, match C.int) C.int {
+	c := cgo.Handle(handle).Value().(KVCursor)
+
+	return kvResult(engine, c.Seek(unsafe.Slice((*byte)(key), int(n)), SeekMatch(match)))
+}
+
+//export goKVCursorMove
+func goKVCursorMove(handle C.uintptr_t, engine *C.unqlite_kv_engine, op C.int) C.int {
+	c := cgo.Handle(handle).Value().(KVCursor)
+
+	var err error
+	switch op {
+	case C.KV_MOVE_FIRST:
+		err = c.First()
+	case C.KV_MOVE_LAST:
+		err = c.Last()
+	case C.KV_MOVE_DELETE:
+		if !c.Valid() {
+			return C.UNQLITE_NOTFOUND
+		}
+		err = c.Delete()
+	default:
+		if !c.Valid() {
+			return C.UNQLITE_EOF
+		}
+		if op == C.KV_MOVE_NEXT {
+			err = c.Next()
+		} else {
+			err = c.Prev()
+		}
+	}
+
+	return kvResult(engine, err)
+}
+
+//export goKVCursorValid
+func goKVCursorValid(handle C.uintptr_t) C.int {
+	if cgo.Handle(handle).Value().(KVCursor).Valid() {
+		return 1
+	}
+
+	return 0
+}
+
+//export goKVCursorReset
+func goKVCursorReset(handle C.uintptr_t) {
+	cgo.Handle(handle).Value().(KVCursor).Reset()
+}
+
+// kvCursorRecord returns the key of the record the cursor is positioned on, or its value.
+func kvCursorRecord(handle C.uintptr_t, engine *C.unqlite_kv_engine, value C.int) ([]byte, C.int) {
+	c := cgo.Handle(handle).Value().(KVCursor)
+	if !c.Valid() {
+		return nil, C.UNQLITE_EOF
+	}
+
+	var b []byte
+	var err error
+	if value != 0 {
+		b, err = c.Value()
+	} else {
+		b, err = c.Key()
+	}
+
+	return b, kvResult(engine, err)
+}
+
+//export goKVCursorLength
+func goKVCursorLength(handle C.uintptr_t, engine *C.unqlite_kv_engine, value C.int, n *C.unqlite_int64) C.int {
+	b, res := kvCursorRecord(handle, engine, value)
+	*n = C.unqlite_int64(len(b))
+
+	return res
+}
+
+//export goKVCursorConsume
+func goKVCursorConsume(handle C.uintptr_t, engine *C.unqlite_kv_engine, value C.int, consumer C.kv_consumer, userData C.uintptr_t) C.int {
+	b, res := kvCursorRecord(handle, engine, value)
+	if res != C.UNQLITE_OK {
+		return res
+	}
+
+	var p unsafe.Pointer
+	if len(b) > 0 {
+		p = unsafe.Pointer(&b[0])
+	}
+
+	return C.kv_consume(consumer, p, C.uint(len(b)), userData)
+}
@@ -0,0 +1,152 @@
+package unqlitego
+
+import (
+	"sort"
+)
+
+// NewOrderedKVEngine returns the constructor of an in-memory Key/Value storage
+// engine keeping its records in the order of cmp, for Library.RegisterKVEngine.
+// Cursors walk the records in that order, Cursor.SeekGE and Cursor.SeekLE find
+// the nearest record in it.
+//
+//	Info().RegisterKVEngine("series", NewOrderedKVEngine(Int64BigEndianComparator))
+//	db, err := OpenDatabase("", WithConfig(Config{KVEngine: "series"}))
+//
+// The records are not written to the database file, they are lost when the
+// database is closed. A nil cmp orders keys bytewise.
+func NewOrderedKVEngine(cmp Comparator) func() KVEngine {
+	if cmp == nil {
+		cmp = BytewiseComparator
+	}
+
+	return func() KVEngine {
+		return &orderedEngine{cmp: cmp}
+	}
+}
+
+// orderedEngine is an OrderedKVEngine keeping its records in sorted slices.
+type orderedEngine struct {
+	cmp Comparator
+
+	// Records in the order of cmp
+	keys, values [][]byte
+}
+
+// orderedCursor is the cursor of an orderedEngine, the index of its record.
+type orderedCursor struct {
+	e *orderedEngine
+	i int
+}
+
+// find returns the index of the first record not smaller than key, and whether it is key.
+func (e *orderedEngine) find(key []byte) (int, bool) {
+	i := sort.Search(len(e.keys), func(i int) bool { return e.cmp(e.keys[i], key) >= 0 })
+
+	return i, i < len(e.keys) && e.cmp(e.keys[i], key) == 0
+}
+
+func (e *orderedEngine) Init(pageSize int) error { return nil }
+
+func (e *orderedEngine) Open(pages int64) error { return nil }
+
+func (e *orderedEngine) Release() {
+	e.keys, e.values = nil, nil
+}
+
+func (e *orderedEngine) Compare(a, b []byte) int {
+	return e.cmp(a, b)
+}
+
+func (e *orderedEngine) Cursor() KVCursor {
+	return &orderedCursor{e: e}
+}
+
+func (e *orderedEngine) Replace(key, value []byte) error {
+	i, ok := e.find(key)
+	if !ok {
+		e.keys = append(e.keys, nil)
+		copy(e.keys[i+1:], e.keys[i:])
+		e.keys[i] = append([]byte{}, key...)
+
+		e.values = append(e.values, nil)
+		copy(e.values[i+1:], e.values[i:])
+	}
+	e.values[i] = append([]byte{}, value...)
+
+	return nil
+}
+
+func (e *orderedEngine) Append(key, value []byte) error {
+	i, ok := e.find(key)
+	if !ok {
+		return e.Replace(key, value)
+	}
+	e.values[i] = append(e.values[i], value...)
+
+	return nil
+}
+
+func (c *orderedCursor) Seek(key []byte, match SeekMatch) error {
+	i, ok := c.e.find(key)
+	switch {
+	case ok:
+	case match == SeekGE && i < len(c.e.keys):
+	case match == SeekLE && i > 0:
+		i--
+	default:
+		c.i = len(c.e.keys)
+		return ErrNotFound
+	}
+	c.i = i
+
+	return nil
+}
+
+func (c *orderedCursor) First() error {
+	c.i = 0
+
+	return nil
+}
+
+func (c *orderedCursor) Last() error {
+	c.i = len(c.e.keys) - 1
+
+	return nil
+}
+
+func (c *orderedCursor) Next() error {
+	c.i++
+
+	return nil
+}
+
+func (c *orderedCursor) Prev() error {
+	c.i--
+
+	return nil
+}
+
+func (c *orderedCursor) Valid() bool {
+	return c.i >= 0 && c.i < len(c.e.keys)
+}
+
+func (c *orderedCursor) Key() ([]byte, error) {
+	return c.e.keys[c.i], nil
+}
+
+func (c *orderedCursor) Value() ([]byte, error) {
+	return c.e.values[c.i], nil
+}
+
+func (c *orderedCursor) Delete() error {
+	c.e.keys = append(c.e.keys[:c.i], c.e.keys[c.i+1:]...)
+	c.e.values = append(c.e.values[:c.i], c.e.values[c.i+1:]...)
+
+	return nil
+}
+
+func (c *orderedCursor) Reset() {
+	c.i = 0
+}
+
+func (c *orderedCursor) Close() {}
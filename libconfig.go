@@ -0,0 +1,139 @@
+package unqlitego
+
+// #include <unqlite.h>
+// #include <wrappers.h>
+// #include <stdlib.h>
+import "C"
+
+import (
+	"runtime/cgo"
+)
+
+// Page sizes of new databases, UNQLITE_DEFAULT_PAGE_SIZE, UNQLITE_MIN_PAGE_SIZE
+// and UNQLITE_MAX_PAGE_SIZE of the engine.
+const (
+	defaultPageSize = 4096
+	minPageSize     = 512
+	maxPageSize     = 65536
+)
+
+// ThreadLevel is the threading mode of the library.
+type ThreadLevel int
+
+// Thread levels, see the UNQLITE_LIB_CONFIG_THREAD_LEVEL_* options of unqlite.h.
+const (
+	// ThreadLevelMulti makes the library thread safe, databases and VMs may be
+	// shared between goroutines. This is the default.
+	ThreadLevelMulti ThreadLevel = iota
+
+	// ThreadLevelSingle disables the mutexes of the library, only one goroutine
+	// at a time may use it.
+	ThreadLevelSingle
+)
+
+// LibraryOptions configures the library with Library.Configure.
+// The zero value is the default configuration.
+type LibraryOptions struct {
+	// ThreadLevel is the threading mode of the library.
+	ThreadLevel ThreadLevel
+
+	// PageSize is the page size of new databases, a power of two between
+	// 512 and 65536. Zero selects the default of 4096. Existing databases
+	// keep the page size they were created with.
+	PageSize int
+
+	// OnMemError is called when the engine runs out of memory. Returning true
+	// retries the allocation, up to three times, false aborts the operation.
+	// It is called with the engine locked, it must not use the library.
+	OnMemError func() bool
+}
+
+// validate checks the LibraryOptions before they are handed to the engine.
+func (o LibraryOptions) validate() error {
+	if o.ThreadLevel != ThreadLevelMulti && o.ThreadLevel != ThreadLevelSingle {
+		return ErrInvalidConfig
+	}
+
+	if o.PageSize != 0 && (o.PageSize < minPageSize || o.PageSize > maxPageSize || o.PageSize&(o.PageSize-1) != 0) {
+		return ErrInvalidConfig
+	}
+
+	return nil
+}
+
+// Configure configures the library. The library can not be configured once it
+// is initialized, which happens when the first database is opened, so close all
+// databases and call Shutdown first. Otherwise ErrLocked is returned.
+// Invalid options return ErrInvalidConfig, the library is left untouched then.
+//
+// The configuration is kept across Shutdown, until Configure is called again.
+func (l *Library) Configure(o LibraryOptions) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.init {
+		return newError("Configure", nil, C.UNQLITE_LOCKED)
+	}
+
+	if err := o.validate(); err != nil {
+		return err
+	}
+
+	var h cgo.Handle
+	if o.OnMemError != nil {
+		h = cgo.NewHandle(o.OnMemError)
+	}
+
+	if res := configure(o.PageSize, o.ThreadLevel, h); res != C.UNQLITE_OK {
+		// Restore the previous configuration
+		configure(l.pageSize, l.threadLevel, l.memError)
+		if h != 0 {
+			h.Delete()
+		}
+		return newError("Configure", nil, res)
+	}
+
+	if l.memError != 0 {
+		l.memError.Delete()
+	}
+	l.pageSize, l.threadLevel, l.memError = o.PageSize, o.ThreadLevel, h
+
+	return nil
+}
+
+// configure applies the configuration to the engine.
+func configure(pageSize int, level ThreadLevel, memError cgo.Handle) C.int {
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+
+	res := C.lib_config_page_size(C.int(pageSize))
+	if res != C.UNQLITE_OK {
+		return res
+	}
+
+	res = C.lib_config_thread_level(threadLevelSingle(level))
+	if res != C.UNQLITE_OK {
+		return res
+	}
+
+	return C.lib_config_mem_err(C.uintptr_t(memError))
+}
+
+// threadLevelSingle returns the argument of lib_config_thread_level for level.
+func threadLevelSingle(level ThreadLevel) C.int {
+	if level == ThreadLevelSingle {
+		return 1
+	}
+
+	return 0
+}
+
+//export goMemError
+func goMemError(handle C.uintptr_t) C.int {
+	if cgo.Handle(handle).Value().(func() bool)() {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,196 @@
+package unqlitego
+
+// #include <unqlite.h>
+import "C"
+
+import (
+	"strings"
+)
+
+// Mode represents the UNQLITE_OPEN_* flags a database is opened with.
+type Mode uint
+
+// Open modes, see the UNQLITE_OPEN_* flags of unqlite.h.
+const (
+	ModeReadOnly       Mode = C.UNQLITE_OPEN_READONLY
+	ModeReadWrite      Mode = C.UNQLITE_OPEN_READWRITE
+	ModeCreate         Mode = C.UNQLITE_OPEN_CREATE
+	ModeExclusive      Mode = C.UNQLITE_OPEN_EXCLUSIVE
+	ModeTempDB         Mode = C.UNQLITE_OPEN_TEMP_DB
+	ModeNoMutex        Mode = C.UNQLITE_OPEN_NOMUTEX
+	ModeOmitJournaling Mode = C.UNQLITE_OPEN_OMIT_JOURNALING
+	ModeInMemory       Mode = C.UNQLITE_OPEN_IN_MEMORY
+	ModeMMap           Mode = C.UNQLITE_OPEN_MMAP
+)
+
+var modeString = []struct {
+	m Mode
+	s string
+}{
+	{ModeReadOnly, "ReadOnly"},
+	{ModeReadWrite, "ReadWrite"},
+	{ModeCreate, "Create"},
+	{ModeExclusive, "Exclusive"},
+	{ModeTempDB, "TempDB"},
+	{ModeNoMutex, "NoMutex"},
+	{ModeOmitJournaling, "OmitJournaling"},
+	{ModeInMemory, "InMemory"},
+	{ModeMMap, "MMap"},
+}
+
+// Has returns a boolean indicating if all flags of f are set in m.
+func (m Mode) Has(f Mode) bool {
+	return m&f == f
+}
+
+// String returns the flags of the Mode joined by '|'.
+func (m Mode) String() string {
+	var flags []string
+	for _, f := range modeString {
+		if m.Has(f.m) {
+			flags = append(flags, f.s)
+		}
+	}
+	if len(flags) == 0 {
+		return "None"
+	}
+
+	return strings.Join(flags, "|")
+}
+
+// validate checks the Mode for combinations the engine would either reject
+// or silently rewrite.
+func (m Mode) validate() error {
+	switch {
+	case m.Has(ModeExclusive):
+		// Reserved for the VFS layer, unqlite_open drops it.
+		return ErrInvalidMode
+	case m.Has(ModeReadOnly) && (m.Has(ModeReadWrite) || m.Has(ModeCreate)):
+		return ErrInvalidMode
+	case m.Has(ModeMMap) && !m.Has(ModeReadOnly):
+		// The engine only maps read-only databases.
+		return ErrInvalidMode
+	case m.Has(ModeInMemory) && (m.Has(ModeReadOnly) || m.Has(ModeMMap)):
+		return ErrInvalidMode
+	case m.Has(ModeTempDB) && m.Has(ModeReadOnly):
+		return ErrInvalidMode
+	}
+
+	return nil
+}
+
+// options holds the configuration collected from the Options
+// passed to OpenDatabase.
+type options struct {
+	mode Mode
+
+	// Configuration applied after open
+	config *Config
+
+	// Key/Value comparison and hash functions
+	cmp  Comparator
+	hash HashFunc
+
+	// Encryption key of the database files
+	keys KeyProvider
+
+	// VFS of the database files
+	vfs VFS
+}
+
+// Option configures how OpenDatabase opens a database.
+type Option func(*options)
+
+// WithMode adds raw UNQLITE_OPEN_* flags to the open mode.
+func WithMode(m Mode) Option {
+	return func(o *options) {
+		o.mode |= m
+	}
+}
+
+// ReadOnly opens an existing database in read-only mode.
+func ReadOnly() Option {
+	return WithMode(ModeReadOnly)
+}
+
+// ReadWrite opens an existing database for reading and writing.
+// The database file is not created if it does not exist.
+func ReadWrite() Option {
+	return WithMode(ModeReadWrite)
+}
+
+// Create opens a database for reading and writing, creating it if it does not exist.
+// This is the default when no access mode is given.
+func Create() Option {
+	return WithMode(ModeCreate)
+}
+
+// InMemory opens a private in-memory database, the filename is ignored.
+func InMemory() Option {
+	return WithMode(ModeInMemory)
+}
+
+// MMap obtains a read-only memory view of the whole database file.
+// Must be combined with ReadOnly.
+func MMap() Option {
+	return WithMode(ModeMMap)
+}
+
+// NoJournal omits journaling for the database. A crash in the middle of a
+// transaction may corrupt the database.
+func NoJournal() Option {
+	return WithMode(ModeOmitJournaling)
+}
+
+// NoMutex disables the per handle mutex. The database handle must not be
+// shared between goroutines.
+func NoMutex() Option {
+	return WithMode(ModeNoMutex)
+}
+
+// TempDB opens a temporary database which is deleted when closed.
+func TempDB() Option {
+	return WithMode(ModeTempDB)
+}
+
+// newOptions applies the Options and fills in the defaults.
+func newOptions(filename string, opts []Option) (*options, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.mode&(ModeReadOnly|ModeReadWrite|ModeCreate) == 0 {
+		o.mode |= ModeCreate
+	}
+
+	// Mirror the engine, an empty name or ':mem:' is an in-memory database.
+	if filename == "" || filename == ":mem:" {
+		o.mode |= ModeInMemory
+	}
+
+	if err := o.mode.validate(); err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(filename, routeMark) {
+		// Reserved for the route to the VFS of the database
+		return nil, ErrInvalidConfig
+	}
+
+	if o.keys != nil && o.mode.Has(ModeInMemory) {
+		return nil, ErrInvalidConfig
+	}
+	if (o.keys != nil || o.vfs != nil) && o.mode.Has(ModeMMap) {
+		// The memory view bypasses the VFS
+		return nil, ErrInvalidMode
+	}
+
+	if o.config != nil {
+		if err := o.config.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return o, nil
+}
@@ -0,0 +1,115 @@
+package unqlitego
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Offsets of the locks in the database file, the same as the built-in VFS of
+// the engine so other processes using UnQLite see the locks.
+const (
+	pendingByte  = 0x40000000
+	reservedByte = pendingByte + 1
+	sharedFirst  = pendingByte + 2
+	sharedSize   = 510
+)
+
+// osVFS is the file system of the operating system, the VFS of the library
+// unless SetVFS installs another one. It follows the built-in VFS of the engine.
+type osVFS struct{}
+
+func (osVFS) Open(name string, mode Mode) (File, error) {
+	flag := os.O_RDONLY
+	if mode.Has(ModeReadWrite) {
+		flag = os.O_RDWR
+	}
+	if mode.Has(ModeCreate) {
+		flag |= os.O_CREATE
+	}
+	if mode.Has(ModeExclusive) {
+		flag |= os.O_EXCL
+	}
+
+	perm := os.FileMode(0644)
+	if mode.Has(ModeTempDB) {
+		perm = 0600
+	}
+
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return newOSFile(f, mode)
+}
+
+func (osVFS) Delete(name string, syncDir bool) error {
+	if err := remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if syncDir {
+		return syncDirectory(filepath.Dir(name))
+	}
+
+	return nil
+}
+
+func (osVFS) Access(name string, flag AccessFlag) (bool, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return false, nil
+	}
+
+	switch flag {
+	case AccessExists:
+		return fi.Size() > 0, nil
+	case AccessReadWrite:
+		return writable(name, fi), nil
+	}
+
+	return true, nil
+}
+
+func (osVFS) FullPathname(name string) (string, error) {
+	return filepath.Abs(name)
+}
+
+func (osVFS) TmpDir() string {
+	return os.TempDir()
+}
+
+func (osVFS) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (osVFS) CurrentTime() time.Time {
+	return time.Now()
+}
+
+func (f *osFile) Truncate(size int64) error {
+	return f.File.Truncate(size)
+}
+
+func (f *osFile) Sync() error {
+	if err := f.File.Sync(); err != nil {
+		return err
+	}
+
+	if f.dir != "" {
+		// Sync the directory entry of a created file once, a failure is not fatal
+		syncDirectory(f.dir)
+		f.dir = ""
+	}
+
+	return nil
+}
+
+func (f *osFile) FileSize() (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
@@ -0,0 +1,302 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package unqlitego
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// osFile is a file opened by osVFS, locked with POSIX advisory locks.
+type osFile struct {
+	*os.File
+
+	// Directory synced by the first Sync of a created file
+	dir string
+
+	// Lock level held through this file
+	level LockLevel
+
+	// Lock state of the process on the file
+	inode *inode
+}
+
+// POSIX locks belong to the process and are released by closing any descriptor
+// of the file, so the files of the process opened on the same inode share their
+// lock state in an inode.
+type inode struct {
+	key inodeKey
+
+	// Highest lock level held by the process
+	level LockLevel
+
+	// Files holding a shared lock, files holding any lock
+	shared, locks int
+
+	// Files opened on the inode
+	refs int
+
+	// Descriptors closed once the process holds no lock
+	unused []*os.File
+}
+
+type inodeKey struct {
+	dev, ino uint64
+}
+
+var (
+	// Guards inodes and their state
+	inodeMu sync.Mutex
+
+	inodes = map[inodeKey]*inode{}
+)
+
+// newOSFile returns the osVFS file of f opened with mode.
+func newOSFile(f *os.File, mode Mode) (File, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	key := inodeKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+
+	if mode.Has(ModeTempDB) {
+		// The file lives until it is closed
+		os.Remove(f.Name())
+	}
+
+	of := &osFile{File: f}
+	if mode.Has(ModeCreate) {
+		of.dir = filepath.Dir(f.Name())
+	}
+
+	inodeMu.Lock()
+	defer inodeMu.Unlock()
+
+	in := inodes[key]
+	if in == nil {
+		in = &inode{key: key}
+		inodes[key] = in
+	}
+	in.refs++
+	of.inode = in
+
+	return of, nil
+}
+
+// release drops a reference to the inode, the inode mutex is held.
+func (in *inode) release() {
+	in.refs--
+	if in.refs == 0 {
+		in.closeUnused()
+		delete(inodes, in.key)
+	}
+}
+
+// closeUnused closes the descriptors kept open for their locks, the inode mutex is held.
+func (in *inode) closeUnused() (err error) {
+	for _, f := range in.unused {
+		if cerr := f.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	in.unused = nil
+
+	return
+}
+
+// setLock sets or clears a POSIX lock of typ on n bytes at off.
+func (f *osFile) setLock(typ int16, off, n int64) error {
+	lk := syscall.Flock_t{Type: typ, Whence: io.SeekStart, Start: off, Len: n}
+
+	return lockError(syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lk))
+}
+
+// lockError converts the error of a lock system call into the code of the built-in VFS.
+func lockError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case syscall.EAGAIN, syscall.ETIMEDOUT, syscall.EBUSY, syscall.EINTR, syscall.ENOLCK, syscall.EACCES:
+		return ErrBusy
+	case syscall.EPERM:
+		return ErrPerm
+	case syscall.EDEADLK:
+		return ErrIO
+	}
+
+	return ErrLockProtocol
+}
+
+func (f *osFile) Close() error {
+	f.Unlock(LockNone)
+
+	inodeMu.Lock()
+	defer inodeMu.Unlock()
+
+	in := f.inode
+	if in.locks > 0 {
+		// Closing the descriptor would release the locks of the other files
+		in.unused = append(in.unused, f.File)
+		in.release()
+		return nil
+	}
+	in.release()
+
+	return f.File.Close()
+}
+
+// Lock raises the lock like the built-in VFS: a shared lock is a read lock on
+// the shared range, taken while holding the pending byte. A reserved lock is
+// a write lock on the reserved byte, an exclusive lock a write lock on the
+// pending byte and the shared range.
+func (f *osFile) Lock(level LockLevel) error {
+	if f.level >= level {
+		return nil
+	}
+
+	inodeMu.Lock()
+	defer inodeMu.Unlock()
+
+	in := f.inode
+	if f.level != in.level && (in.level >= LockPending || level > LockShared) {
+		// Another file of the process holds a lock precluding it
+		return ErrBusy
+	}
+
+	if level == LockShared && (in.level == LockShared || in.level == LockReserved) {
+		f.level = LockShared
+		in.shared++
+		in.locks++
+		return nil
+	}
+
+	if level == LockShared || (level == LockExclusive && f.level < LockPending) {
+		typ := int16(syscall.F_WRLCK)
+		if level == LockShared {
+			typ = syscall.F_RDLCK
+		}
+		if err := f.setLock(typ, pendingByte, 1); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	switch {
+	case level == LockShared:
+		err = f.setLock(syscall.F_RDLCK, sharedFirst, sharedSize)
+		// Drop the temporary pending lock
+		if uerr := f.setLock(syscall.F_UNLCK, pendingByte, 1); err == nil && uerr != nil {
+			return uerr
+		}
+		if err == nil {
+			in.locks++
+			in.shared = 1
+		}
+	case level == LockExclusive && in.shared > 1:
+		// Another file of the process still holds a shared lock
+		err = ErrBusy
+	case level == LockReserved:
+		err = f.setLock(syscall.F_WRLCK, reservedByte, 1)
+	default:
+		err = f.setLock(syscall.F_WRLCK, sharedFirst, sharedSize)
+	}
+
+	if err == nil {
+		f.level, in.level = level, level
+	} else if level == LockExclusive {
+		f.level, in.level = LockPending, LockPending
+	}
+
+	return err
+}
+
+func (f *osFile) Unlock(level LockLevel) error {
+	if f.level <= level {
+		return nil
+	}
+
+	inodeMu.Lock()
+	defer inodeMu.Unlock()
+
+	in := f.inode
+	if f.level > LockShared {
+		if level == LockShared {
+			if err := f.setLock(syscall.F_RDLCK, sharedFirst, sharedSize); err != nil {
+				return err
+			}
+		}
+		// Release the pending and reserved bytes
+		if err := f.setLock(syscall.F_UNLCK, pendingByte, 2); err != nil {
+			return err
+		}
+		in.level = LockShared
+	}
+
+	var err error
+	if level == LockNone {
+		// The process releases its locks with the last shared lock
+		in.shared--
+		if in.shared == 0 {
+			err = f.setLock(syscall.F_UNLCK, 0, 0)
+			in.level = LockNone
+		}
+
+		in.locks--
+		if in.locks == 0 {
+			if cerr := in.closeUnused(); err == nil {
+				err = cerr
+			}
+		}
+	}
+
+	if err == nil || level == LockNone {
+		f.level = level
+	}
+
+	return err
+}
+
+func (f *osFile) CheckReservedLock() (bool, error) {
+	inodeMu.Lock()
+	defer inodeMu.Unlock()
+
+	if f.inode.level > LockShared {
+		return true, nil
+	}
+
+	// Otherwise see if another process holds it
+	lk := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: io.SeekStart, Start: reservedByte, Len: 1}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_GETLK, &lk); err != nil {
+		return false, lockError(err)
+	}
+
+	return lk.Type != syscall.F_UNLCK, nil
+}
+
+// remove deletes the file name.
+func remove(name string) error {
+	return os.Remove(name)
+}
+
+// syncDirectory commits the entries of the directory dir to stable storage.
+func syncDirectory(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// writable reports whether the process may read and write the file name.
+func writable(name string, fi os.FileInfo) bool {
+	// R_OK | W_OK
+	return syscall.Access(name, 0x4|0x2) == nil
+}
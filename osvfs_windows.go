@@ -0,0 +1,193 @@
+//go:build windows
+// +build windows
+
+package unqlitego
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// osFile is a file opened by osVFS, locked with Windows byte range locks.
+type osFile struct {
+	*os.File
+
+	// Directory synced by the first Sync of a created file, unused on Windows
+	dir string
+
+	// Lock level held through this file
+	level LockLevel
+
+	// Name the file is deleted by on Close, for a temporary database
+	temp string
+}
+
+var (
+	kernel32       = syscall.NewLazyDLL("kernel32.dll")
+	procLockFile   = kernel32.NewProc("LockFile")
+	procLockFileEx = kernel32.NewProc("LockFileEx")
+	procUnlockFile = kernel32.NewProc("UnlockFile")
+)
+
+// LOCKFILE_FAIL_IMMEDIATELY
+const lockfileFailImmediately = 0x1
+
+// newOSFile returns the osVFS file of f opened with mode.
+func newOSFile(f *os.File, mode Mode) (File, error) {
+	of := &osFile{File: f}
+	if mode.Has(ModeTempDB) {
+		of.temp = f.Name()
+	}
+
+	return of, nil
+}
+
+// lockFile takes an exclusive lock on n bytes at off.
+func (f *osFile) lockFile(off, n uint32) bool {
+	r, _, _ := procLockFile.Call(f.Fd(), uintptr(off), 0, uintptr(n), 0)
+	return r != 0
+}
+
+// unlockFile releases the lock on n bytes at off.
+func (f *osFile) unlockFile(off, n uint32) bool {
+	r, _, _ := procUnlockFile.Call(f.Fd(), uintptr(off), 0, uintptr(n), 0)
+	return r != 0
+}
+
+// readLock takes a shared lock on the shared range.
+func (f *osFile) readLock() bool {
+	ol := syscall.Overlapped{Offset: sharedFirst}
+	r, _, _ := procLockFileEx.Call(f.Fd(), lockfileFailImmediately, 0, sharedSize, 0, uintptr(unsafe.Pointer(&ol)))
+	return r != 0
+}
+
+func (f *osFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	if f.temp != "" {
+		return remove(f.temp)
+	}
+
+	return nil
+}
+
+// Lock raises the lock like the built-in VFS: a shared lock is a shared lock on
+// the shared range, taken while holding the pending byte. A reserved lock is
+// a lock on the reserved byte, an exclusive lock a lock on the pending byte and
+// the shared range.
+func (f *osFile) Lock(level LockLevel) error {
+	if f.level >= level {
+		return nil
+	}
+
+	ok := true
+	pending := false
+	next := f.level
+	if f.level == LockNone || (level == LockExclusive && f.level == LockReserved) {
+		// The pending lock might be held by a reader for a moment
+		for i := 0; i < 3; i++ {
+			if ok = f.lockFile(pendingByte, 1); ok {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		pending = ok
+	}
+
+	switch {
+	case !ok:
+	case level == LockShared:
+		if ok = f.readLock(); ok {
+			next = LockShared
+		}
+	case level == LockReserved:
+		if ok = f.lockFile(reservedByte, 1); ok {
+			next = LockReserved
+		}
+	case level == LockExclusive:
+		next = LockPending
+		f.unlockFile(sharedFirst, sharedSize)
+		if ok = f.lockFile(sharedFirst, sharedSize); ok {
+			next = LockExclusive
+		} else {
+			f.readLock()
+		}
+	}
+
+	if pending && level == LockShared {
+		f.unlockFile(pendingByte, 1)
+	}
+	f.level = next
+
+	if !ok {
+		return ErrBusy
+	}
+
+	return nil
+}
+
+func (f *osFile) Unlock(level LockLevel) error {
+	if f.level <= level {
+		return nil
+	}
+
+	var err error
+	held := f.level
+	if held >= LockExclusive {
+		f.unlockFile(sharedFirst, sharedSize)
+		if level == LockShared && !f.readLock() {
+			err = ErrIO
+		}
+	}
+	if held >= LockReserved {
+		f.unlockFile(reservedByte, 1)
+	}
+	if level == LockNone && held >= LockShared {
+		f.unlockFile(sharedFirst, sharedSize)
+	}
+	if held >= LockPending {
+		f.unlockFile(pendingByte, 1)
+	}
+	f.level = level
+
+	return err
+}
+
+func (f *osFile) CheckReservedLock() (bool, error) {
+	if f.level >= LockReserved {
+		return true, nil
+	}
+
+	if !f.lockFile(reservedByte, 1) {
+		return true, nil
+	}
+	f.unlockFile(reservedByte, 1)
+
+	return false, nil
+}
+
+// remove deletes the file name, retrying while another process such as a virus
+// scanner holds it open.
+func remove(name string) (err error) {
+	for i := 0; i < 5; i++ {
+		if err = os.Remove(name); err == nil || os.IsNotExist(err) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return err
+}
+
+// syncDirectory does nothing, Windows does not sync directories.
+func syncDirectory(dir string) error {
+	return nil
+}
+
+// writable reports whether the file name is not read-only.
+func writable(name string, fi os.FileInfo) bool {
+	return fi.Mode().Perm()&0200 != 0
+}
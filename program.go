@@ -0,0 +1,193 @@
+package unqlitego
+
+import (
+	"container/list"
+	"sync"
+)
+
+// maxPrograms is the number of programs a database caches, the least recently
+// prepared ones are evicted beyond it.
+const maxPrograms = 64
+
+// Program represents a JX9 script compiled once and executed many times.
+//
+// Each concurrent execution needs its own VM, Acquire hands out an idle VM
+// of the program or compiles a new one, Release resets it for the next use.
+//
+//	prog, err := db.Prepare(`$total = $a + $b;`)
+//	if err != nil {
+//		return err
+//	}
+//	vm, err := prog.Acquire()
+//	if err != nil {
+//		return err
+//	}
+//	defer prog.Release(vm)
+//	vm.SetVar("a", 1)
+//	vm.SetVar("b", 2)
+//	vm.Run()
+type Program struct {
+	// Database Pointer
+	db *Database
+
+	// Script text
+	src string
+
+	// Element of the program in the cache of the database
+	elem *list.Element
+
+	// Guards idle and evicted
+	mu sync.Mutex
+
+	// Reset VMs ready for execution
+	idle []*VM
+
+	// Program was evicted from the cache, its VMs are no longer kept
+	evicted bool
+}
+
+// Prepare returns the Program compiled from the script src.
+// Programs are cached by script text, preparing the same script again returns
+// the same Program. The cache keeps the 64 most recently prepared programs,
+// an evicted Program remains usable but no longer keeps idle VMs.
+// It is safe for concurrent use.
+func (db *Database) Prepare(src string) (*Program, error) {
+	db.mu.Lock()
+	p, ok := db.progs[src]
+	if ok {
+		db.progLRU.MoveToFront(p.elem)
+	}
+	db.mu.Unlock()
+	if ok {
+		return p, nil
+	}
+
+	// Compile outside the lock, the first VM also validates the script.
+	vm := NewVM()
+	if _, err := db.Compile(src, vm); err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	if p, ok := db.progs[src]; ok {
+		db.mu.Unlock()
+		// Prepared concurrently, keep the VM for later use.
+		vm.prog = p
+		p.put(vm)
+		return p, nil
+	}
+
+	p = &Program{
+		db:   db,
+		src:  src,
+		idle: []*VM{vm},
+	}
+	vm.prog, vm.idle = p, true
+	if db.progs == nil {
+		db.progs = make(map[string]*Program)
+		db.progLRU = list.New()
+	}
+	db.progs[src] = p
+	p.elem = db.progLRU.PushFront(p)
+
+	var old *Program
+	if db.progLRU.Len() > maxPrograms {
+		old = db.progLRU.Remove(db.progLRU.Back()).(*Program)
+		delete(db.progs, old.src)
+	}
+	db.mu.Unlock()
+
+	if old != nil {
+		// Closing the VMs takes the database lock.
+		old.evict()
+	}
+
+	return p, nil
+}
+
+// Source returns the script text of the program.
+func (p *Program) Source() string {
+	return p.src
+}
+
+// Acquire returns a VM ready to execute the program.
+func (p *Program) Acquire() (*VM, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		vm := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		vm.idle = false
+		p.mu.Unlock()
+		return vm, nil
+	}
+	p.mu.Unlock()
+
+	vm := NewVM()
+	if _, err := p.db.Compile(p.src, vm); err != nil {
+		return nil, err
+	}
+	vm.prog = p
+
+	return vm, nil
+}
+
+// Release resets vm and returns it to the program for reuse, the next caller
+// does not see the state left by the previous one: the variables set with SetVar
+// are reset to null and the constants are deleted. A VM with its output or error
+// report configured cannot be restored, it is closed instead, as is a VM which
+// cannot be reset. Foreign functions are kept.
+//
+// A VM which was not acquired from the program, or which was already released,
+// returns ErrInvalid and is left untouched.
+func (p *Program) Release(vm *VM) error {
+	if vm.prog != p {
+		return ErrInvalid
+	}
+
+	// Reserve the VM, so a concurrent second Release fails as well.
+	p.mu.Lock()
+	if vm.idle {
+		p.mu.Unlock()
+		return ErrInvalid
+	}
+	vm.idle = true
+	p.mu.Unlock()
+
+	if p.db.closed() || vm.out != nil || vm.errs != nil {
+		return vm.Close()
+	}
+
+	if err := vm.clear(); err != nil {
+		vm.Close()
+		return err
+	}
+
+	p.put(vm)
+
+	return nil
+}
+
+// put adds a reset VM to the idle VMs, or closes it once the program is evicted.
+func (p *Program) put(vm *VM) {
+	p.mu.Lock()
+	if p.evicted {
+		p.mu.Unlock()
+		vm.Close()
+		return
+	}
+	vm.idle = true
+	p.idle = append(p.idle, vm)
+	p.mu.Unlock()
+}
+
+// evict closes the idle VMs of a program removed from the cache.
+func (p *Program) evict() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle, p.evicted = nil, true
+	p.mu.Unlock()
+
+	for _, vm := range idle {
+		vm.Close()
+	}
+}
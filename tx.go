@@ -0,0 +1,269 @@
+package unqlitego
+
+import (
+	"context"
+	"io"
+)
+
+// storeChunkSize is the size of the chunks appended by StoreFrom.
+const storeChunkSize = 64 * 1024
+
+// Tx represents a transaction on the database.
+//
+// Writable transactions are serialized, only one can be open on a Database at any time.
+// Read-only transactions do not take the write lock and observe the state of the database
+// handle, including changes of an open writable transaction.
+// Operations performed directly on the Database are not serialized with transactions.
+type Tx struct {
+	// Database Pointer
+	db *Database
+
+	// Context the transaction was started with
+	ctx context.Context
+
+	// Transaction allows writes
+	writable bool
+
+	// Transaction is committed or rolled back
+	done bool
+}
+
+// BeginTx starts a new transaction. A writable transaction waits for any other writable
+// transaction to finish, or until the context is done.
+func (db *Database) BeginTx(ctx context.Context, writable bool) (*Tx, error) {
+	return db.beginTx(ctx, writable, true)
+}
+
+// beginTx starts a new transaction. Without wait a writable transaction returns
+// ErrTxOpen instead of waiting for another writable transaction to finish.
+func (db *Database) beginTx(ctx context.Context, writable, wait bool) (*Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tx := &Tx{
+		db:       db,
+		ctx:      ctx,
+		writable: writable,
+	}
+	if !writable {
+		return tx, nil
+	}
+
+	if wait {
+		select {
+		case db.wlock <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	} else {
+		select {
+		case db.wlock <- struct{}{}:
+		default:
+			return nil, ErrTxOpen
+		}
+	}
+
+	if err := db.Begin(); err != nil {
+		<-db.wlock
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// View executes fn within a read-only transaction.
+// The error returned by fn is passed back to the caller.
+//
+// The transaction is not isolated from writers: it takes no lock and reads the
+// current state of the database handle, including the uncommitted changes of an
+// open writable transaction and writes performed directly on the Database.
+func (db *Database) View(fn func(*Tx) error) error {
+	tx, err := db.BeginTx(context.Background(), false)
+	if err != nil {
+		return err
+	}
+	defer tx.close()
+
+	return fn(tx)
+}
+
+// Update executes fn within a writable transaction. The transaction is committed when
+// fn returns nil, and rolled back when fn returns an error or panics.
+// When fn already committed or rolled back the transaction, nil is returned.
+func (db *Database) Update(fn func(*Tx) error) error {
+	return db.update(fn, true)
+}
+
+// update executes fn within a writable transaction, see beginTx for wait.
+func (db *Database) update(fn func(*Tx) error, wait bool) (err error) {
+	tx, err := db.beginTx(context.Background(), true, wait)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if tx.done {
+		return nil
+	}
+
+	return tx.Commit()
+}
+
+// Writable returns a boolean indicating if the transaction allows writes.
+func (tx *Tx) Writable() bool {
+	return tx.writable
+}
+
+// Commit the transaction to the database. If the context of the transaction
+// is done, the transaction is rolled back and the context error is returned.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if !tx.writable {
+		tx.close()
+		return nil
+	}
+
+	if err := tx.ctx.Err(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err := tx.db.Commit()
+	if err != nil {
+		tx.db.Rollback()
+	}
+	tx.close()
+
+	return err
+}
+
+// Rollback the transaction.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if !tx.writable {
+		tx.close()
+		return nil
+	}
+
+	err := tx.db.Rollback()
+	tx.close()
+
+	return err
+}
+
+// close marks the transaction done and releases the write lock.
+func (tx *Tx) close() {
+	if tx.done {
+		return
+	}
+	tx.done = true
+
+	if tx.writable {
+		<-tx.db.wlock
+	}
+}
+
+// check verifies the transaction can still be used.
+func (tx *Tx) check(write bool) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if write && !tx.writable {
+		return ErrTxReadOnly
+	}
+
+	return tx.ctx.Err()
+}
+
+// Store will store a new Key/Value pair within the transaction.
+func (tx *Tx) Store(key, value []byte) error {
+	if err := tx.check(true); err != nil {
+		return err
+	}
+
+	return tx.db.Store(key, value)
+}
+
+// Append will append the value to the record within the transaction.
+func (tx *Tx) Append(key, value []byte) error {
+	if err := tx.check(true); err != nil {
+		return err
+	}
+
+	return tx.db.Append(key, value)
+}
+
+// StoreFrom will store a record read from r in chunks within the transaction.
+// When reading from r fails the record is left partially written, rolling back
+// the transaction discards it. It returns the number of bytes stored.
+func (tx *Tx) StoreFrom(key []byte, r io.Reader) (int64, error) {
+	if err := tx.check(true); err != nil {
+		return 0, err
+	}
+
+	// Truncate any previous record.
+	if err := tx.db.Store(key, nil); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	buf := make([]byte, storeChunkSize)
+	for {
+		m, err := r.Read(buf)
+		if m > 0 {
+			if err := tx.db.Append(key, buf[:m]); err != nil {
+				return n, err
+			}
+			n += int64(m)
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// Fetch a record within the transaction.
+func (tx *Tx) Fetch(key []byte) ([]byte, error) {
+	if err := tx.check(false); err != nil {
+		return nil, err
+	}
+
+	return tx.db.Fetch(key)
+}
+
+// Delete a record within the transaction.
+func (tx *Tx) Delete(key []byte) error {
+	if err := tx.check(true); err != nil {
+		return err
+	}
+
+	return tx.db.Delete(key)
+}
+
+// Cursor creates a new database cursor within the transaction.
+// The cursor must be closed before the transaction is finished.
+func (tx *Tx) Cursor() (*Cursor, error) {
+	if err := tx.check(false); err != nil {
+		return nil, err
+	}
+
+	return tx.db.Cursor()
+}
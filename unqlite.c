@@ -461,6 +461,7 @@ typedef sxi64 unqlite_int64;
 #define UNQLITE_CONFIG_KV_ENGINE           4  /* ONE ARGUMENT: const char *zKvName */
 #define UNQLITE_CONFIG_DISABLE_AUTO_COMMIT 5  /* NO ARGUMENTS */
 #define UNQLITE_CONFIG_GET_KV_NAME         6  /* ONE ARGUMENT: const char **pzPtr */
+#define UNQLITE_CONFIG_ERR_LOG_RESET       7  /* NO ARGUMENTS */
 /*
  * UnQLite/Jx9 Virtual Machine Configuration Commands.
  *
@@ -489,8 +490,9 @@ typedef sxi64 unqlite_int64;
 #define UNQLITE_VM_CONFIG_ENV_ATTR         9  /* THREE ARGUMENTS: const char *zKey, const char *zValue, int nLen */
 #define UNQLITE_VM_CONFIG_EXEC_VALUE      10  /* ONE ARGUMENT: unqlite_value **ppValue */
 #define UNQLITE_VM_CONFIG_IO_STREAM       11  /* ONE ARGUMENT: const unqlite_io_stream *pStream */
-#define UNQLITE_VM_CONFIG_ARGV_ENTRY      12  /* ONE ARGUMENT: const char *zValue */
+#define UNQLITE_VM_CONFIG_ARGV_ENTRY      12  /* ONE ARGUMENT: const char *zValue, NULL clears $argv */
 #define UNQLITE_VM_CONFIG_EXTRACT_OUTPUT  13  /* TWO ARGUMENTS: const void **ppOut, unsigned int *pOutputLen */
+#define UNQLITE_VM_CONFIG_ERR_CONSUMER    14  /* TWO ARGUMENTS: int (*xConsumer)(const void *pMsg, unsigned int nLen, void *pUserData), void *pUserData */
 /*
  * Storage engine configuration commands.
  *
@@ -892,6 +894,7 @@ UNQLITE_APIEXPORT int unqlite_compile_file(unqlite *pDb,const char *zPath,unqlit
 UNQLITE_APIEXPORT int unqlite_vm_config(unqlite_vm *pVm,int iOp,...);
 UNQLITE_APIEXPORT int unqlite_vm_exec(unqlite_vm *pVm);
 UNQLITE_APIEXPORT int unqlite_vm_reset(unqlite_vm *pVm);
+UNQLITE_APIEXPORT int unqlite_vm_interrupt(unqlite_vm *pVm);
 UNQLITE_APIEXPORT int unqlite_vm_release(unqlite_vm *pVm);
 UNQLITE_APIEXPORT int unqlite_vm_dump(unqlite_vm *pVm, int (*xConsumer)(const void *, unsigned int, void *), void *pUserData);
 UNQLITE_APIEXPORT unqlite_value * unqlite_vm_extract_variable(unqlite_vm *pVm,const char *zVarname);
@@ -1206,8 +1209,9 @@ typedef sxi64 jx9_int64;
 #define JX9_VM_CONFIG_ENV_ATTR         UNQLITE_VM_CONFIG_ENV_ATTR  /* THREE ARGUMENTS: const char *zKey, const char *zValue, int nLen */
 #define JX9_VM_CONFIG_EXEC_VALUE       UNQLITE_VM_CONFIG_EXEC_VALUE  /* ONE ARGUMENT: jx9_value **ppValue */
 #define JX9_VM_CONFIG_IO_STREAM        UNQLITE_VM_CONFIG_IO_STREAM  /* ONE ARGUMENT: const jx9_io_stream *pStream */
-#define JX9_VM_CONFIG_ARGV_ENTRY       UNQLITE_VM_CONFIG_ARGV_ENTRY  /* ONE ARGUMENT: const char *zValue */
+#define JX9_VM_CONFIG_ARGV_ENTRY       UNQLITE_VM_CONFIG_ARGV_ENTRY  /* ONE ARGUMENT: const char *zValue, NULL clears $argv */
 #define JX9_VM_CONFIG_EXTRACT_OUTPUT   UNQLITE_VM_CONFIG_EXTRACT_OUTPUT  /* TWO ARGUMENTS: const void **ppOut, unsigned int *pOutputLen */
+#define JX9_VM_CONFIG_ERR_CONSUMER     UNQLITE_VM_CONFIG_ERR_CONSUMER  /* TWO ARGUMENTS: int (*xConsumer)(const void *pMsg, unsigned int nLen, void *pUserData), void *pUserData */
 /*
  * Global Library Configuration Commands.
  *
@@ -2686,11 +2690,13 @@ struct jx9_vm
 	int nMaxDepth;             /* Maximum allowed recusion depth */
 	sxu32 nOutputLen;          /* Total number of generated output */
 	jx9_output_consumer sVmConsumer; /* Registered output consumer callback */
+	jx9_output_consumer sErrConsumer; /* Run-time error consumer callback, the output consumer when not set */
 	int iAssertFlags;          /* Assertion flags */
 	jx9_value sAssertCallback; /* Callback to call on failed assertions */
 	sxi32 iExitStatus;         /* Script exit status */
 	jx9_gen_state sCodeGen;    /* Code generator module */
 	jx9_vm *pNext, *pPrev;      /* List of active VM's */
+	volatile int nInterrupt;   /* Abort the running program when set, 2 once honored, see unqlite_vm_interrupt() */
 	sxu32 nMagic;              /* Sanity check against misuse */
 };
 /*
@@ -3313,6 +3319,7 @@ struct unqlite_db
 	Pager *pPager;              /* Pager and Transaction manager */
 	jx9 *pJx9;                  /* Jx9 Engine handle */
 	unqlite_kv_cursor *pCursor; /* Database cursor for common usage */
+	sxu32 nCursor;              /* Total number of open cursors, pCursor included */
 };
 /*
  * Each database connection is an instance of the following structure.
@@ -3522,10 +3529,12 @@ UNQLITE_PRIVATE int unqlitePagerOpen(
   unsigned int iFlags      /* flags controlling this file */
   );
 UNQLITE_PRIVATE int unqlitePagerRegisterKvEngine(Pager *pPager,unqlite_kv_methods *pMethods);
+UNQLITE_PRIVATE int unqlitePagerSelectKvEngine(Pager *pPager,const char *zName);
 UNQLITE_PRIVATE unqlite_kv_engine * unqlitePagerGetKvEngine(unqlite *pDb);
 UNQLITE_PRIVATE int unqlitePagerBegin(Pager *pPager);
 UNQLITE_PRIVATE int unqlitePagerCommit(Pager *pPager);
 UNQLITE_PRIVATE int unqlitePagerRollback(Pager *pPager,int bResetKvEngine);
+UNQLITE_PRIVATE int unqlitePagerInWriteTx(Pager *pPager);
 UNQLITE_PRIVATE void unqlitePagerRandomString(Pager *pPager,char *zBuf,sxu32 nLen);
 UNQLITE_PRIVATE sxu32 unqlitePagerRandomNum(Pager *pPager);
 #endif /* __UNQLITEINT_H__ */
@@ -3677,6 +3686,16 @@ static sxi32 unqliteCoreConfigure(sxi32 nOp, va_list ap)
 					rc = UNQLITE_INVALID;
 					break;
 			}
+			if( sUnqlMPGlobal.kv_storage.pAllocator == 0 ){
+				/* The set of engines is created when the library is initialized */
+				rc = UNQLITE_LOCKED;
+				break;
+			}
+			if( unqliteFindKVStore(pMethods->zName,SyStrlen(pMethods->zName)) ){
+				/* Another engine with the same name is installed */
+				rc = UNQLITE_EXISTS;
+				break;
+			}
 			/* Install it */
 			rc = SySetPut(&sUnqlMPGlobal.kv_storage,(const void *)&pMethods);
 			break;
@@ -3794,8 +3813,11 @@ int unqlite_lib_config(int nConfigOp,...)
 	va_list ap;
 	int rc;
 	if( sUnqlMPGlobal.nMagic == UNQLITE_LIB_MAGIC ){
-		/* Library is already initialized, this operation is forbidden */
-		return UNQLITE_LOCKED;
+		if( nConfigOp != UNQLITE_LIB_CONFIG_STORAGE_ENGINE ){
+			/* Library is already initialized, this operation is forbidden */
+			return UNQLITE_LOCKED;
+		}
+		/* Storage engines can be installed at any time once initialized */
 	}
 	va_start(ap,nConfigOp);
 	rc = unqliteCoreConfigure(nConfigOp,ap);
@@ -3965,6 +3987,7 @@ static void unqliteCoreShutdown(void)
 	}
 	/* Release the storage methods container */
 	SySetRelease(&sUnqlMPGlobal.kv_storage);
+	sUnqlMPGlobal.kv_storage.pAllocator = 0;
 #if defined(UNQLITE_ENABLE_THREADS)
 	/* Release the mutex subsystem */
 	if( sUnqlMPGlobal.pMutexMethods ){
@@ -4278,6 +4301,20 @@ static int unqliteConfigure(unqlite *pDb,int nOp,va_list ap)
 		}
 		break;
 								 }
+	case UNQLITE_CONFIG_ERR_LOG_RESET:
+		/* Discard the error log entries */
+		SyBlobReset(&pDb->sErr);
+		break;
+	case UNQLITE_CONFIG_KV_ENGINE: {
+		/* Switch to another installed KV storage engine */
+		const char *zName = va_arg(ap,const char *);
+		if( SX_EMPTY_STR(zName) ){
+			rc = UNQLITE_INVALID;
+			break;
+		}
+		rc = unqlitePagerSelectKvEngine(pDb->sDB.pPager,zName);
+		break;
+								   }
 	case UNQLITE_CONFIG_DISABLE_AUTO_COMMIT:{
 		/* Disable auto-commit */
 		pDb->iFlags |= UNQLITE_FL_DISABLE_AUTO_COMMIT;
@@ -4556,6 +4593,7 @@ int unqlite_vm_config(unqlite_vm *pVm,int iOp,...)
  */
 int unqlite_vm_exec(unqlite_vm *pVm)
 {
+	int bTx;
 	int rc;
 	if( UNQLITE_VM_MISUSE(pVm) ){
 		return UNQLITE_CORRUPT;
@@ -4568,8 +4606,14 @@ int unqlite_vm_exec(unqlite_vm *pVm)
 			 return UNQLITE_ABORT; /* Another thread have released this instance */
 	 }
 #endif
+	 /* Whether the program writes in a transaction opened before it runs */
+	 bTx = unqlitePagerInWriteTx(pVm->pDb->sDB.pPager);
 	/* Execute the Jx9 bytecode program */
 	 rc = jx9VmByteCodeExec(pVm->pJx9Vm);
+	 if( rc == UNQLITE_ABORT && !bTx ){
+		 /* Interrupted, discard the writes of the program */
+		 unqlitePagerRollback(pVm->pDb->sDB.pPager,TRUE);
+	 }
 #if defined(UNQLITE_ENABLE_THREADS)
 	 /* Leave DB mutex */
 	 SyMutexLeave(sUnqlMPGlobal.pMutexMethods,pVm->pMutex); /* NO-OP if sUnqlMPGlobal.nThreadingLevel != UNQLITE_THREAD_LEVEL_MULTI */
@@ -4650,6 +4694,24 @@ int unqlite_vm_reset(unqlite_vm *pVm)
 #endif
 	return rc;
 }
+/*
+ * [CAPIREF: unqlite_vm_interrupt()]
+ * Request the running program of the VM to abort as soon as possible.
+ * This function does not take the VM mutex so it can be called from
+ * another thread while unqlite_vm_exec() is running. unqlite_vm_exec()
+ * returns UNQLITE_ABORT when the program was interrupted, the request
+ * is cleared once it returns and by unqlite_vm_reset().
+ * The write transaction opened by the interrupted program is rolled back,
+ * the writes made in a transaction which was open before it ran are kept.
+ */
+int unqlite_vm_interrupt(unqlite_vm *pVm)
+{
+	if( UNQLITE_VM_MISUSE(pVm) ){
+		return UNQLITE_CORRUPT;
+	}
+	pVm->pJx9Vm->nInterrupt = 1;
+	return UNQLITE_OK;
+}
 /*
  * [CAPIREF: unqlite_vm_dump()]
  * Please refer to the official documentation for function purpose and expected parameters.
@@ -42549,6 +42611,7 @@ JX9_PRIVATE sxi32 jx9VmReset(jx9_vm *pVm)
 	}
 	/* TICKET 1433-003: As of this version, the VM is automatically reset */
 	SyBlobReset(&pVm->sConsumer);
+	pVm->nInterrupt = 0;
 	jx9MemObjRelease(&pVm->sExec);
 	/* Set the ready flag */
 	pVm->nMagic = JX9_VM_RUN;
@@ -42918,6 +42981,17 @@ JX9_PRIVATE sxi32 jx9VmConfigure(
 		/* Run-Time Error report */
 		pVm->bErrReport = 1;
 		break;
+	case JX9_VM_CONFIG_ERR_CONSUMER:{
+		/* Run-Time Error consumer */
+		ProcConsumer xConsumer;
+		void *pUserData;
+		xConsumer = va_arg(ap, ProcConsumer);
+		pUserData = va_arg(ap, void *);
+		/* Install the error consumer, a NULL consumer restores the output consumer */
+		pVm->sErrConsumer.xConsumer = xConsumer;
+		pVm->sErrConsumer.pUserData = pUserData;
+		break;
+									}
 	case JX9_VM_CONFIG_RECURSION_DEPTH:{
 		/* Recursion depth */
 		int nDepth = va_arg(ap, int);
@@ -43017,6 +43091,12 @@ JX9_PRIVATE sxi32 jx9VmConfigure(
 		}
 		/* Point to the hashmap */
 		pMap = (jx9_hashmap *)pValue->x.pOther;
+		if( zValue == 0 ){
+			/* A NULL entry clears the arguments */
+			jx9HashmapRelease(pMap, FALSE);
+			SyBlobReset(&pVm->sArgv);
+			break;
+		}
 		/* Perform the insertion */
 		rc = VmHashmapInsert(pMap, 0, 0, zValue,-1);
 		if( rc == SXRET_OK && zValue && zValue[0] != 0 ){
@@ -43151,6 +43231,10 @@ static sxi32 VmCallErrorHandler(jx9_vm *pVm, SyBlob *pMsg)
 {
 	jx9_output_consumer *pCons = &pVm->sVmConsumer;
 	sxi32 rc = SXRET_OK;
+	if( pVm->sErrConsumer.xConsumer ){
+		/* Errors go to the dedicated consumer, they are not part of the output */
+		pCons = &pVm->sErrConsumer;
+	}
 	/* Append a new line */
 #ifdef __WINNT__
 	SyBlobAppend(pMsg, "\r\n", sizeof("\r\n")-1);
@@ -43159,8 +43243,10 @@ static sxi32 VmCallErrorHandler(jx9_vm *pVm, SyBlob *pMsg)
 #endif
 	/* Invoke the output consumer callback */
 	rc = pCons->xConsumer(SyBlobData(pMsg), SyBlobLength(pMsg), pCons->pUserData);
-	/* Increment output length */
-	pVm->nOutputLen += SyBlobLength(pMsg);
+	if( pCons == &pVm->sVmConsumer ){
+		/* Increment output length */
+		pVm->nOutputLen += SyBlobLength(pMsg);
+	}
 	
 	return rc;
 }
@@ -43330,6 +43416,11 @@ static sxi32 VmByteCodeExec(
 	pc = 0;
 	/* Execute as much as we can */
 	for(;;){
+		if( pVm->nInterrupt ){
+			/* Interrupted by unqlite_vm_interrupt(), record that the request was honored */
+			pVm->nInterrupt = 2;
+			goto Abort;
+		}
 		/* Fetch the instruction to execute */
 		pInstr = &aInstr[pc];
 		rc = SXRET_OK;
@@ -45625,6 +45716,12 @@ JX9_PRIVATE sxi32 jx9VmByteCodeExec(jx9_vm *pVm)
 	 * so that any following call to [jx9_vm_exec()] without calling
 	 * [jx9_vm_reset()] first would fail.
 	 */
+	if( pVm->nInterrupt > 1 ){
+		/* Interrupted by unqlite_vm_interrupt() */
+		pVm->nInterrupt = 0;
+		return SXERR_ABORT;
+	}
+	pVm->nInterrupt = 0;
 	return SXRET_OK;
 }
 /*
@@ -56973,13 +57070,14 @@ static int unqliteFinalizeJournal(Pager *pPager,int *pRetry,int close_jrnl)
 	}
 	/* Sync the journal and close it */
 	rc = unqliteOsSync(pPager->pjfd,UNQLITE_SYNC_NORMAL);
+	if( rc != UNQLITE_OK ){
+		/* The journal may not have reached the disk, leave the database untouched */
+		unqliteGenError(pPager->pDb,"IO error while syncing the journal, rollback your database");
+		return rc;
+	}
 	if( close_jrnl ){
 		/* close the journal file */
-		if( UNQLITE_OK != unqliteOsCloseFree(pPager->pAllocator,pPager->pjfd) ){
-			if( rc != UNQLITE_OK /* unqliteOsSync */ ){
-				return rc;
-			}
-		}
+		unqliteOsCloseFree(pPager->pAllocator,pPager->pjfd);
 		pPager->pjfd = 0;
 	}
 	if( (*pRetry) == 1 ){
@@ -57614,6 +57712,7 @@ UNQLITE_PRIVATE int unqliteInitCursor(unqlite *pDb,unqlite_kv_cursor **ppOut)
 	if( pMethods->xCursorInit ){
 		pMethods->xCursorInit(pCur);
 	}
+	pDb->sDB.nCursor++;
 	/* All done */
 	*ppOut = pCur;
 	return UNQLITE_OK;
@@ -57632,6 +57731,7 @@ UNQLITE_PRIVATE int unqliteReleaseCursor(unqlite *pDb,unqlite_kv_cursor *pCur)
 	}
 	/* Finally, free the whole instance */
 	SyMemBackendPoolFree(&pDb->sMem,pCur);
+	pDb->sDB.nCursor--;
 	return UNQLITE_OK;
 }
 /*
@@ -57660,22 +57760,22 @@ static int pager_kv_io_init(Pager *pPager,unqlite_kv_methods *pMethods,unqlite_k
 /*
  * Allocate, initialize and register a new KV storage engine
  * within this database instance.
+ * The old engine is released only once the new one is ready, it is
+ * left in place when the new one cannot be initialized.
  */
 UNQLITE_PRIVATE int unqlitePagerRegisterKvEngine(Pager *pPager,unqlite_kv_methods *pMethods)
 {
 	unqlite_db *pStorage = &pPager->pDb->sDB;
+	unqlite_kv_engine *pOld = pPager->pEngine;
 	unqlite *pDb = pPager->pDb;
 	unqlite_kv_engine *pEngine;
+	unqlite_kv_cursor *pCursor;
 	unqlite_kv_io *pIo;
 	sxu32 nByte;
 	int rc;
-	if( pPager->pEngine ){
-		if( pMethods == pPager->pEngine->pIo->pMethods ){
-			/* Ticket 1432: Same implementation */
-			return UNQLITE_OK;
-		}
-		/* Release the old KV engine */
-		pager_release_kv_engine(pPager);
+	if( pOld && pMethods == pOld->pIo->pMethods ){
+		/* Ticket 1432: Same implementation */
+		return UNQLITE_OK;
 	}
 	/* Allocate a new KV engine instance */
 	nByte = (sxu32)pMethods->szKv;
@@ -57701,23 +57801,41 @@ UNQLITE_PRIVATE int unqlitePagerRegisterKvEngine(Pager *pPager,unqlite_kv_method
 		rc = pMethods->xInit(pEngine,unqliteGetPageSize());
 		if( rc != UNQLITE_OK ){
 			unqliteGenErrorFormat(pDb,
-				"xInit() method of the underlying KV engine '%z' failed",&pPager->sKv);
+				"xInit() method of the underlying KV engine '%s' failed",pMethods->zName);
 			goto fail;
 		}
 		pEngine->pIo = pIo;
 	}
-	pPager->pEngine = pEngine;
 	/* Allocate a new cursor */
-	rc = unqliteInitCursor(pDb,&pStorage->pCursor);
+	pPager->pEngine = pEngine;
+	rc = unqliteInitCursor(pDb,&pCursor);
 	if( rc != UNQLITE_OK ){
+		if( pMethods->xRelease ){
+			pMethods->xRelease(pEngine);
+		}
+		pPager->pEngine = pOld;
 		goto fail;
 	}
+	if( pOld ){
+		/* Release the old KV engine and its cursor */
+		pPager->pEngine = pOld;
+		pager_release_kv_engine(pPager);
+	}
+	pPager->pEngine = pEngine;
+	pStorage->pCursor = pCursor;
 	return UNQLITE_OK;
 fail:
 	SyMemBackendFree(&pDb->sMem,pEngine);
 	SyMemBackendFree(&pDb->sMem,pIo);
 	return rc;
 }
+/*
+ * Return TRUE if a write transaction is open.
+ */
+UNQLITE_PRIVATE int unqlitePagerInWriteTx(Pager *pPager)
+{
+	return pPager->iState >= PAGER_WRITER_LOCKED;
+}
 /*
  * Return the underlying KV storage engine instance.
  */
@@ -57725,6 +57843,67 @@ UNQLITE_PRIVATE unqlite_kv_engine * unqlitePagerGetKvEngine(unqlite *pDb)
 {
 	return pDb->sDB.pPager->pEngine;
 }
+/*
+ * Switch to the installed KV storage engine zName.
+ * The records of the current engine would be lost, UNQLITE_LOCKED is returned
+ * while it holds any record or while cursors other than the common one are open.
+ * A database file which already holds records keeps the engine recorded in its
+ * header, UNQLITE_LOCKED is returned for any other engine.
+ */
+UNQLITE_PRIVATE int unqlitePagerSelectKvEngine(Pager *pPager,const char *zName)
+{
+	unqlite_db *pStorage = &pPager->pDb->sDB;
+	unqlite_kv_methods *pMethods;
+	unqlite_kv_methods *pCurrent;
+	int rc;
+	pMethods = unqliteFindKVStore(zName,SyStrlen(zName));
+	if( pMethods == 0 ){
+		unqliteGenErrorFormat(pPager->pDb,"No such Key/Value storage engine '%s'",zName);
+		return UNQLITE_NOTIMPLEMENTED;
+	}
+	if( pPager->is_mem && pMethods == unqliteExportDiskKvStorage() ){
+		/* The hash engine stores its records in the pages of the database file */
+		unqliteGenErrorFormat(pPager->pDb,"The Key/Value storage engine '%s' requires a database file",zName);
+		return UNQLITE_INVALID;
+	}
+	if( !pPager->is_mem ){
+		/* Read the database header first */
+		rc = pager_shared_lock(pPager);
+		if( rc != UNQLITE_OK ){
+			return rc;
+		}
+	}
+	if( !pPager->is_mem && pPager->dbByteSize > 0 && pMethods != pPager->pEngine->pIo->pMethods ){
+		unqliteGenErrorFormat(pPager->pDb,
+			"The database was created with the Key/Value storage engine '%z'",&pPager->sKv);
+		return UNQLITE_LOCKED;
+	}
+	if( pMethods == pPager->pEngine->pIo->pMethods ){
+		/* Already selected */
+		return UNQLITE_OK;
+	}
+	if( pStorage->nCursor > (pStorage->pCursor ? 1 : 0) ){
+		unqliteGenError(pPager->pDb,"Cannot switch the Key/Value storage engine while cursors are open");
+		return UNQLITE_LOCKED;
+	}
+	pCurrent = pPager->pEngine->pIo->pMethods;
+	if( pStorage->pCursor && pCurrent->xFirst(pStorage->pCursor) == UNQLITE_OK
+		&& pCurrent->xValid(pStorage->pCursor) ){
+		unqliteGenError(pPager->pDb,"Cannot switch the Key/Value storage engine of a database holding records");
+		return UNQLITE_LOCKED;
+	}
+	rc = unqlitePagerRegisterKvEngine(pPager,pMethods);
+	if( rc != UNQLITE_OK ){
+		return rc;
+	}
+	/* Recorded in the header of a new database file */
+	SyStringInitFromBuf(&pPager->sKv,pMethods->zName,SyStrlen(pMethods->zName));
+	if( pPager->pHeader && pPager->iState >= PAGER_WRITER_LOCKED ){
+		/* The header of the new file is already created, not written yet */
+		pager_write_db_header(pPager);
+	}
+	return UNQLITE_OK;
+}
 /*
 * Allocate and initialize a new Pager object. The pager should
 * eventually be freed by passing it to unqlitePagerClose().
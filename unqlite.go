@@ -7,6 +7,7 @@ import "C"
 
 import (
 	"fmt"
+	"runtime/cgo"
 	"sync"
 )
 
@@ -35,6 +36,21 @@ type Library struct {
 
 	// ThreadSafe Mutex
 	mu *sync.Mutex
+
+	// Handle of the Go VFS, zero until the library is first initialized
+	vfs cgo.Handle
+
+	// Go Key/Value storage engines, installed again after a Shutdown
+	engines []*kvEngine
+
+	// Threading mode, set again after a Shutdown
+	threadLevel ThreadLevel
+
+	// Page size of new databases, zero for the default
+	pageSize int
+
+	// Handle of the out-of-memory callback, zero for none
+	memError cgo.Handle
 }
 
 // Info returns the UnQLite Library.
@@ -58,8 +74,28 @@ func (l *Library) Init() {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	l.initialize()
+}
+
+// initialize initializes the native library, the library is locked.
+func (l *Library) initialize() {
+	if !l.init {
+		// Shutdown resets the threading mode
+		C.lib_config_thread_level(threadLevelSingle(l.threadLevel))
+	}
+	if l.vfs == 0 {
+		// Route the files through Go, WithVFS and WithEncryption need it
+		l.configureVFS(osVFS{})
+	}
+
 	// Initialize Native Library
 	C.unqlite_lib_init()
+	if !l.init {
+		// Install the registered engines, Shutdown releases them
+		for _, e := range l.engines {
+			C.lib_config_kv_engine(e.methods)
+		}
+	}
 	l.init = true
 }
 
@@ -101,7 +137,7 @@ func (l *Library) Shutdown() error {
 
 	res := C.unqlite_lib_shutdown()
 	if res != C.UNQLITE_OK {
-		return UnQLiteError(res)
+		return newError("Shutdown", nil, res)
 	}
 	l.init = false
 
@@ -114,6 +150,8 @@ func (l *Library) Shutdown() error {
 //
 // 	( UnQLiteError <= 0 ) Native Errors
 //	( UnQLiteError >  0 ) UnQLite Go Errors.
+//
+// Database operations wrap the UnQLiteError in an Error, use errors.Is to test for a specific code.
 type UnQLiteError int
 
 // Error returns the string representation of the UnQLiteError.
@@ -130,7 +168,47 @@ func (e UnQLiteError) String() string {
 	return e.Error()
 }
 
+// UnQLite Go errors.
+const (
+	// ErrInvalidMode is returned when an invalid combination of open modes is requested.
+	ErrInvalidMode UnQLiteError = iota + 1
+
+	// ErrInvalidConfig is returned when a configuration value is rejected before reaching the engine.
+	ErrInvalidConfig
+
+	// ErrTxDone is returned when a transaction is used after it was committed or rolled back.
+	ErrTxDone
+
+	// ErrTxReadOnly is returned when a write is attempted within a read-only transaction.
+	ErrTxReadOnly
+
+	// ErrKVSlots is returned when more than 16 open databases register Key/Value functions at once.
+	ErrKVSlots
+
+	// ErrUnsupportedType is returned when a Go value can not be converted to a JX9 value.
+	ErrUnsupportedType
+
+	// ErrAborted is returned when the execution of a VM is aborted by its context.
+	ErrAborted
+
+	// ErrWrongKey is returned when an encrypted database is opened with another key.
+	ErrWrongKey
+
+	// ErrTxOpen is returned when an operation requires that no transaction is open.
+	ErrTxOpen
+)
+
 var errString = map[UnQLiteError]string{
+	ErrInvalidMode:     "Invalid combination of open modes",
+	ErrInvalidConfig:   "Invalid configuration value",
+	ErrTxDone:          "Transaction has already been committed or rolled back",
+	ErrTxReadOnly:      "Transaction is read-only",
+	ErrKVSlots:         "No free Key/Value function slot",
+	ErrUnsupportedType: "Unsupported type for JX9 value",
+	ErrAborted:         "Execution aborted",
+	ErrWrongKey:        "Wrong encryption key",
+	ErrTxOpen:          "Transaction is open",
+
 	C.UNQLITE_OK:             "OK",
 	C.UNQLITE_LOCKERR:        "Locking protocol error",
 	C.UNQLITE_READ_ONLY:      "Read only Key/Value storage engine",
@@ -393,6 +393,7 @@ typedef sxi64 unqlite_int64;
 #define UNQLITE_CONFIG_KV_ENGINE           4  /* ONE ARGUMENT: const char *zKvName */
 #define UNQLITE_CONFIG_DISABLE_AUTO_COMMIT 5  /* NO ARGUMENTS */
 #define UNQLITE_CONFIG_GET_KV_NAME         6  /* ONE ARGUMENT: const char **pzPtr */
+#define UNQLITE_CONFIG_ERR_LOG_RESET       7  /* NO ARGUMENTS */
 /*
  * UnQLite/Jx9 Virtual Machine Configuration Commands.
  *
@@ -421,8 +422,9 @@ typedef sxi64 unqlite_int64;
 #define UNQLITE_VM_CONFIG_ENV_ATTR         9  /* THREE ARGUMENTS: const char *zKey, const char *zValue, int nLen */
 #define UNQLITE_VM_CONFIG_EXEC_VALUE      10  /* ONE ARGUMENT: unqlite_value **ppValue */
 #define UNQLITE_VM_CONFIG_IO_STREAM       11  /* ONE ARGUMENT: const unqlite_io_stream *pStream */
-#define UNQLITE_VM_CONFIG_ARGV_ENTRY      12  /* ONE ARGUMENT: const char *zValue */
+#define UNQLITE_VM_CONFIG_ARGV_ENTRY      12  /* ONE ARGUMENT: const char *zValue, NULL clears $argv */
 #define UNQLITE_VM_CONFIG_EXTRACT_OUTPUT  13  /* TWO ARGUMENTS: const void **ppOut, unsigned int *pOutputLen */
+#define UNQLITE_VM_CONFIG_ERR_CONSUMER    14  /* TWO ARGUMENTS: int (*xConsumer)(const void *pMsg, unsigned int nLen, void *pUserData), void *pUserData */
 /*
  * Storage engine configuration commands.
  *
@@ -825,6 +827,7 @@ UNQLITE_APIEXPORT int unqlite_compile_file(unqlite *pDb,const char *zPath,unqlit
 UNQLITE_APIEXPORT int unqlite_vm_config(unqlite_vm *pVm,int iOp,...);
 UNQLITE_APIEXPORT int unqlite_vm_exec(unqlite_vm *pVm);
 UNQLITE_APIEXPORT int unqlite_vm_reset(unqlite_vm *pVm);
+UNQLITE_APIEXPORT int unqlite_vm_interrupt(unqlite_vm *pVm);
 UNQLITE_APIEXPORT int unqlite_vm_release(unqlite_vm *pVm);
 UNQLITE_APIEXPORT int unqlite_vm_dump(unqlite_vm *pVm, int (*xConsumer)(const void *, unsigned int, void *), void *pUserData);
 UNQLITE_APIEXPORT unqlite_value * unqlite_vm_extract_variable(unqlite_vm *pVm,const char *zVarname);
@@ -2,9 +2,23 @@ package unqlitego
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"time"
 
 	. "github.com/r7kamura/gospel"
 )
@@ -28,6 +42,44 @@ func TestLibrary(t *testing.T) {
 				Expect(Info().Copyright()).To(Equal, "Copyright (C) Symisc Systems, S.U.A.R.L [Mrad Chems Eddine <chm@symisc.net>] 2012-2018, http://unqlite.org/")
 			})
 		})
+
+		Context("Configure", func() {
+			It("Library.Configure", func() {
+				Expect(Info().Shutdown()).To(NotExist)
+				defer func() {
+					Expect(Info().Shutdown()).To(NotExist)
+					Expect(Info().Configure(LibraryOptions{})).To(NotExist)
+				}()
+				Expect(Info().Configure(LibraryOptions{PageSize: 1000})).To(Equal, error(ErrInvalidConfig))
+				Expect(Info().Configure(LibraryOptions{PageSize: 256})).To(Equal, error(ErrInvalidConfig))
+				Expect(Info().Configure(LibraryOptions{ThreadLevel: 7})).To(Equal, error(ErrInvalidConfig))
+				Expect(Info().Configure(LibraryOptions{
+					ThreadLevel: ThreadLevelSingle,
+					PageSize:    65536,
+					OnMemError:  func() bool { return false },
+				})).To(NotExist)
+				Expect(Info().IsThreadSafe()).To(Equal, false)
+
+				name := filepath.Join(t.TempDir(), "page.db")
+				db, err := OpenDatabase(name)
+				Expect(err).To(NotExist)
+				Expect(errors.Is(Info().Configure(LibraryOptions{}), ErrLocked)).To(Equal, true)
+				Expect(errors.Is(Info().Configure(LibraryOptions{PageSize: 1000}), ErrLocked)).To(Equal, true)
+				Expect(errors.Is(Info().Configure(LibraryOptions{ThreadLevel: 7}), ErrLocked)).To(Equal, true)
+				Expect(db.Store([]byte("key"), []byte("value"))).To(NotExist)
+				Expect(db.Close()).To(NotExist)
+				fi, err := os.Stat(name)
+				Expect(err).To(NotExist)
+				Expect(fi.Size() > 0 && fi.Size()%65536 == 0).To(Equal, true)
+
+				// The thread level is kept across Shutdown
+				Expect(Info().Shutdown()).To(NotExist)
+				Expect(Info().IsThreadSafe()).To(Equal, false)
+				Expect(Info().Shutdown()).To(NotExist)
+				Expect(Info().Configure(LibraryOptions{})).To(NotExist)
+				Expect(Info().IsThreadSafe()).To(Equal, true)
+			})
+		})
 	})
 }
 
@@ -112,52 +164,1896 @@ func TestModule(t *testing.T) {
 	})
 }
 
-func BenchmarkFileStore(b *testing.B) {
-	b.StopTimer()
-	f, err := ioutil.TempFile("", "sample.db")
-	if err != nil {
-		panic(err)
-	}
-	db, _ := NewDatabase(f.Name())
-	b.StartTimer()
-	for i := 0; i < b.N; i++ {
-		db.Store([]byte(fmt.Sprintf("%d", i)), []byte("abcdefghijklmnopabcdefghijklmnopabcdefghijklmnopabcdefghijklmnop"))
-	}
+func TestOpenDatabase(t *testing.T) {
+	var db *Database
+	var name string
+
+	Describe(t, "Normal", func() {
+		Context("Modes", func() {
+			It("OpenDatabase.Default", func() {
+				f, err := ioutil.TempFile("", "sample.db")
+				if err != nil {
+					panic(err)
+				}
+				name = f.Name()
+				db, err = OpenDatabase(name)
+				Expect(err).To(NotExist)
+				Expect(db.Mode()).To(Equal, ModeCreate)
+				Expect(db.Store([]byte("sample"), []byte("value"))).To(NotExist)
+				Expect(db.Close()).To(NotExist)
+			})
+			It("OpenDatabase.ReadOnly", func() {
+				var err error
+				db, err = OpenDatabase(name, ReadOnly(), MMap())
+				Expect(err).To(NotExist)
+				Expect(db.Mode()).To(Equal, ModeReadOnly|ModeMMap)
+				value, err := db.Fetch([]byte("sample"))
+				Expect(err).To(NotExist)
+				Expect(string(value)).To(Equal, "value")
+				Expect(db.Store([]byte("sample"), []byte("other"))).To(Exist)
+				Expect(db.Close()).To(NotExist)
+			})
+			It("OpenDatabase.InMemory", func() {
+				var err error
+				db, err = OpenDatabase("", NoJournal())
+				Expect(err).To(NotExist)
+				Expect(db.Mode().Has(ModeInMemory|ModeOmitJournaling)).To(Equal, true)
+				Expect(db.Close()).To(NotExist)
+			})
+			It("OpenDatabase.Invalid", func() {
+				_, err := OpenDatabase(name, ReadOnly(), Create())
+				Expect(err).To(Equal, error(ErrInvalidMode))
+				_, err = OpenDatabase(name, MMap())
+				Expect(err).To(Equal, error(ErrInvalidMode))
+				_, err = OpenDatabase("", ReadOnly())
+				Expect(err).To(Equal, error(ErrInvalidMode))
+			})
+		})
+	})
 }
 
-func BenchmarkFileFetch(b *testing.B) {
-	b.StopTimer()
-	f, err := ioutil.TempFile("", "sample.db")
-	if err != nil {
-		panic(err)
-	}
-	db, _ := NewDatabase(f.Name())
-	for i := 0; i < b.N; i++ {
-		db.Store([]byte(fmt.Sprintf("%d", i)), []byte("abcdefghijklmnopabcdefghijklmnopabcdefghijklmnopabcdefghijklmnop"))
-	}
-	b.StartTimer()
-	for i := 0; i < b.N; i++ {
-		_, _ = db.Fetch([]byte(fmt.Sprintf("%d", i)))
+func TestConfig(t *testing.T) {
+	var db *Database
+
+	Describe(t, "Normal", func() {
+		Context("Config", func() {
+			It("OpenDatabase.WithConfig", func() {
+				var err error
+				db, err = OpenDatabase("", WithConfig(Config{MaxPageCache: 1024, DisableAutoCommit: true}))
+				Expect(err).To(NotExist)
+				Expect(db).To(Exist)
+			})
+			It("OpenDatabase.WithConfig.Invalid", func() {
+				_, err := OpenDatabase("", WithConfig(Config{MaxPageCache: 1}))
+				Expect(err).To(Equal, error(ErrInvalidConfig))
+			})
+			It("Database.KVEngine", func() {
+				name, err := db.KVEngine()
+				Expect(err).To(NotExist)
+				Expect(name).To(Equal, "mem")
+			})
+			It("Database.SetMaxPageCache", func() {
+				Expect(db.SetMaxPageCache(512)).To(NotExist)
+				Expect(db.SetMaxPageCache(16)).To(Equal, error(ErrInvalidConfig))
+			})
+			It("Database.SetKVEngine.Empty", func() {
+				Expect(db.SetKVEngine("")).To(Equal, error(ErrInvalidConfig))
+			})
+			It("Database.SetKVEngine", func() {
+				Expect(errors.Is(db.SetKVEngine("hash"), ErrInvalid)).To(Equal, true)
+
+				file, err := OpenDatabase(filepath.Join(t.TempDir(), "engine.db"))
+				Expect(err).To(NotExist)
+				defer file.Close()
+				Expect(file.SetKVEngine("mem")).To(NotExist)
+				name, err := file.KVEngine()
+				Expect(err).To(NotExist)
+				Expect(name).To(Equal, "mem")
+				Expect(file.Store([]byte("key"), []byte("value"))).To(NotExist)
+				v, err := file.Fetch([]byte("key"))
+				Expect(err).To(NotExist)
+				Expect(string(v)).To(Equal, "value")
+			})
+			It("Database.Close", func() {
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+}
+
+func TestError(t *testing.T) {
+	var db *Database
+
+	Describe(t, "Normal", func() {
+		Context("Error", func() {
+			It("NewDatabase", func() {
+				var err error
+				db, err = NewDatabase("")
+				Expect(err).To(NotExist)
+			})
+			It("Database.Fetch.NotFound", func() {
+				_, err := db.Fetch([]byte("missing"))
+				Expect(errors.Is(err, ErrNotFound)).To(Equal, true)
+				var e *Error
+				Expect(errors.As(err, &e)).To(Equal, true)
+				Expect(e.Op).To(Equal, "Fetch")
+				Expect(string(e.Key)).To(Equal, "missing")
+				Expect(e.Error()).To(Equal, `Fetch "missing": No such record`)
+			})
+			It("Database.Compile.Error", func() {
+				vm := NewVM()
+				elog, err := db.Compile("$a = ;", vm)
+				Expect(errors.Is(err, ErrCompile)).To(Equal, true)
+				Expect(elog == "").To(Equal, false)
+				var ce *CompileError
+				Expect(errors.As(err, &ce)).To(Equal, true)
+				Expect(ce.Log).To(Equal, elog)
+				var e *Error
+				Expect(errors.As(err, &e)).To(Equal, true)
+				Expect(e.Log).To(Equal, elog)
+			})
+			It("Database.ErrLog", func() {
+				for i := 0; i < 2; i++ {
+					var e *Error
+					Expect(errors.As(db.SetKVEngine("missing"), &e)).To(Equal, true)
+					Expect(e.Log).To(Equal, "No such Key/Value storage engine 'missing'")
+				}
+				elog, err := db.ErrLog()
+				Expect(err).To(NotExist)
+				Expect(elog).To(Equal, "")
+			})
+			It("Database.Close", func() {
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+}
+
+func TestTx(t *testing.T) {
+	var db *Database
+	fail := errors.New("fail")
+
+	Describe(t, "Normal", func() {
+		Context("Tx", func() {
+			It("NewDatabase", func() {
+				f, err := ioutil.TempFile("", "sample.db")
+				if err != nil {
+					panic(err)
+				}
+				db, err = NewDatabase(f.Name())
+				Expect(err).To(NotExist)
+			})
+			It("Database.Update", func() {
+				err := db.Update(func(tx *Tx) error {
+					return tx.Store([]byte("sample"), []byte("value"))
+				})
+				Expect(err).To(NotExist)
+			})
+			It("Database.View", func() {
+				err := db.View(func(tx *Tx) error {
+					value, err := tx.Fetch([]byte("sample"))
+					Expect(string(value)).To(Equal, "value")
+					Expect(tx.Store([]byte("sample"), nil)).To(Equal, error(ErrTxReadOnly))
+					return err
+				})
+				Expect(err).To(NotExist)
+			})
+			It("Database.Update.Error", func() {
+				err := db.Update(func(tx *Tx) error {
+					Expect(tx.Delete([]byte("sample"))).To(NotExist)
+					return fail
+				})
+				Expect(err).To(Equal, fail)
+				_, err = db.Fetch([]byte("sample"))
+				Expect(err).To(NotExist)
+			})
+			It("Database.Update.Done", func() {
+				err := db.Update(func(tx *Tx) error {
+					Expect(tx.Store([]byte("done"), []byte("value"))).To(NotExist)
+					return tx.Commit()
+				})
+				Expect(err).To(NotExist)
+				err = db.Update(func(tx *Tx) error {
+					return tx.Rollback()
+				})
+				Expect(err).To(NotExist)
+			})
+			It("Database.Update.Panic", func() {
+				func() {
+					defer func() { recover() }()
+					db.Update(func(tx *Tx) error {
+						tx.Delete([]byte("sample"))
+						panic(fail)
+					})
+				}()
+				_, err := db.Fetch([]byte("sample"))
+				Expect(err).To(NotExist)
+			})
+			It("Database.BeginTx.Serialized", func() {
+				tx, err := db.BeginTx(context.Background(), true)
+				Expect(err).To(NotExist)
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				defer cancel()
+				_, err = db.BeginTx(ctx, true)
+				Expect(err).To(Equal, context.DeadlineExceeded)
+				Expect(tx.Commit()).To(NotExist)
+				Expect(tx.Commit()).To(Equal, error(ErrTxDone))
+			})
+			It("Database.Close", func() {
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+}
+
+func TestKVFuncs(t *testing.T) {
+	var db *Database
+	var name string
+
+	Describe(t, "Normal", func() {
+		Context("Comparator", func() {
+			It("CaseInsensitiveComparator", func() {
+				Expect(CaseInsensitiveComparator([]byte("Key"), []byte("kEY"))).To(Equal, 0)
+				Expect(CaseInsensitiveHash([]byte("Key"))).To(Equal, CaseInsensitiveHash([]byte("kEY")))
+				Expect(CaseInsensitiveComparator([]byte("a"), []byte("B"))).To(Equal, -1)
+			})
+			It("Int64BigEndianComparator", func() {
+				neg, pos := make([]byte, 8), make([]byte, 8)
+				binary.BigEndian.PutUint64(neg, uint64(1<<64-1))
+				binary.BigEndian.PutUint64(pos, 1)
+				Expect(Int64BigEndianComparator(neg, pos)).To(Equal, -1)
+				Expect(Int64BigEndianComparator(pos, []byte("x"))).To(Equal, -1)
+				Expect(Int64BigEndianComparator([]byte("x"), pos)).To(Equal, 1)
+				Expect(ReverseComparator([]byte("a"), []byte("b"))).To(Equal, 1)
+				Expect(BytewiseComparator([]byte("a"), []byte("b"))).To(Equal, -1)
+			})
+		})
+		Context("Database", func() {
+			It("OpenDatabase.WithComparator", func() {
+				f, err := ioutil.TempFile("", "sample.db")
+				if err != nil {
+					panic(err)
+				}
+				name = f.Name()
+				db, err = OpenDatabase(name, WithHashFunc(CaseInsensitiveHash), WithComparator(CaseInsensitiveComparator))
+				Expect(err).To(NotExist)
+			})
+			It("Database.Fetch.CaseInsensitive", func() {
+				Expect(db.Store([]byte("Sample"), []byte("value"))).To(NotExist)
+				value, err := db.Fetch([]byte("sAMPLE"))
+				Expect(err).To(NotExist)
+				Expect(string(value)).To(Equal, "value")
+			})
+			It("Database.SetHashFunc.Locked", func() {
+				err := db.SetHashFunc(CaseInsensitiveHash)
+				Expect(errors.Is(err, ErrLocked)).To(Equal, true)
+				Expect(db.Close()).To(NotExist)
+			})
+			It("OpenDatabase.WithHashFunc.Reopen", func() {
+				var err error
+				db, err = OpenDatabase(name, WithHashFunc(CaseInsensitiveHash), WithComparator(CaseInsensitiveComparator))
+				Expect(err).To(NotExist)
+				value, err := db.Fetch([]byte("SAMPLE"))
+				Expect(err).To(NotExist)
+				Expect(string(value)).To(Equal, "value")
+				Expect(db.Close()).To(NotExist)
+			})
+			It("Database.Close.KVSlot", func() {
+				for i := 0; i < 32; i++ {
+					db, err := OpenDatabase("", WithComparator(CaseInsensitiveComparator))
+					Expect(err).To(NotExist)
+					Expect(db.Close()).To(NotExist)
+				}
+			})
+		})
+	})
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("fail")
+}
+
+func TestStream(t *testing.T) {
+	var db *Database
+	src := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+
+	Describe(t, "Normal", func() {
+		Context("Stream", func() {
+			It("NewDatabase", func() {
+				f, err := ioutil.TempFile("", "sample.db")
+				if err != nil {
+					panic(err)
+				}
+				db, err = NewDatabase(f.Name())
+				Expect(err).To(NotExist)
+				Expect(db.Store([]byte("large"), src)).To(NotExist)
+			})
+			It("Database.FetchTo", func() {
+				var buf bytes.Buffer
+				n, err := db.FetchTo([]byte("large"), &buf)
+				Expect(err).To(NotExist)
+				Expect(n).To(Equal, int64(len(src)))
+				Expect(bytes.Equal(buf.Bytes(), src)).To(Equal, true)
+			})
+			It("Database.FetchTo.NotFound", func() {
+				var buf bytes.Buffer
+				_, err := db.FetchTo([]byte("missing"), &buf)
+				Expect(errors.Is(err, ErrNotFound)).To(Equal, true)
+			})
+			It("Database.FetchTo.WriteError", func() {
+				_, err := db.FetchTo([]byte("large"), failWriter{})
+				Expect(err.Error()).To(Equal, "fail")
+			})
+			It("Cursor.KeyTo.ValueTo", func() {
+				cursor, err := db.Cursor()
+				Expect(err).To(NotExist)
+				Expect(cursor.Seek([]byte("large"))).To(NotExist)
+				var key, value bytes.Buffer
+				_, err = cursor.KeyTo(&key)
+				Expect(err).To(NotExist)
+				Expect(key.String()).To(Equal, "large")
+				n, err := cursor.ValueTo(&value)
+				Expect(err).To(NotExist)
+				Expect(n).To(Equal, int64(len(src)))
+				Expect(cursor.Close()).To(NotExist)
+			})
+			It("Database.StoreFrom", func() {
+				n, err := db.StoreFrom([]byte("copy"), bytes.NewReader(src))
+				Expect(err).To(NotExist)
+				Expect(n).To(Equal, int64(len(src)))
+				size, err := db.ValueSize([]byte("copy"))
+				Expect(err).To(NotExist)
+				Expect(size).To(Equal, int64(len(src)))
+			})
+			It("Database.StoreFrom.Empty", func() {
+				n, err := db.StoreFrom([]byte("empty"), bytes.NewReader(nil))
+				Expect(err).To(NotExist)
+				Expect(n).To(Equal, int64(0))
+				value, err := db.Fetch([]byte("empty"))
+				Expect(err).To(NotExist)
+				Expect(len(value)).To(Equal, 0)
+			})
+			It("Database.StoreFrom.ReadError", func() {
+				r := io.MultiReader(bytes.NewReader([]byte("partial")), iotest.ErrReader(errors.New("fail")))
+				_, err := db.StoreFrom([]byte("copy"), r)
+				Expect(err.Error()).To(Equal, "fail")
+				size, err := db.ValueSize([]byte("copy"))
+				Expect(err).To(NotExist)
+				Expect(size).To(Equal, int64(len(src)))
+			})
+			It("Database.StoreFrom.TxOpen", func() {
+				err := db.Update(func(tx *Tx) error {
+					_, err := db.StoreFrom([]byte("nested"), bytes.NewReader(src))
+					return err
+				})
+				Expect(err).To(Equal, error(ErrTxOpen))
+				_, err = db.ValueSize([]byte("nested"))
+				Expect(errors.Is(err, ErrNotFound)).To(Equal, true)
+			})
+			It("Database.Close", func() {
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+}
+
+func TestIterator(t *testing.T) {
+	var db *Database
+
+	collect := func(it *Iterator, err error) []string {
+		Expect(err).To(NotExist)
+		var keys []string
+		for it.Next() {
+			keys = append(keys, string(it.Key()))
+		}
+		Expect(it.Err()).To(NotExist)
+		Expect(it.Close()).To(NotExist)
+		sort.Strings(keys)
+		return keys
 	}
+
+	Describe(t, "Normal", func() {
+		Context("Iterator", func() {
+			It("NewDatabase", func() {
+				var err error
+				db, err = NewDatabase("")
+				Expect(err).To(NotExist)
+				for _, k := range []string{"a1", "a2", "b1", "b2", "c1"} {
+					Expect(db.Store([]byte(k), []byte("v"+k))).To(NotExist)
+				}
+			})
+			It("Database.Range", func() {
+				keys := collect(db.Range([]byte("a2"), []byte("c1"), nil))
+				Expect(keys).To(Equal, []string{"a2", "b1", "b2"})
+			})
+			It("Database.Prefix", func() {
+				keys := collect(db.Prefix([]byte("b")))
+				Expect(keys).To(Equal, []string{"b1", "b2"})
+			})
+			It("Database.Range.Reverse.Limit", func() {
+				keys := collect(db.Range(nil, nil, &IteratorOptions{Reverse: true, KeysOnly: true, Offset: 1, Limit: 2}))
+				Expect(len(keys)).To(Equal, 2)
+			})
+			It("Database.Range.Values", func() {
+				it, err := db.Prefix([]byte("c"))
+				Expect(err).To(NotExist)
+				Expect(it.Next()).To(Equal, true)
+				Expect(string(it.Value())).To(Equal, "vc1")
+				Expect(it.Next()).To(Equal, false)
+				Expect(it.Close()).To(NotExist)
+			})
+			It("Database.All", func() {
+				entries, errf := db.PrefixAll([]byte("a"))
+				var keys []string
+				for k, v := range entries {
+					Expect(string(v)).To(Equal, "v"+string(k))
+					keys = append(keys, string(k))
+				}
+				Expect(errf()).To(NotExist)
+				sort.Strings(keys)
+				Expect(keys).To(Equal, []string{"a1", "a2"})
+			})
+			It("Database.Close", func() {
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+		Context("Ordered", func() {
+			var step *stepEngine
+
+			walk := func(it *Iterator, err error) string {
+				Expect(err).To(NotExist)
+				step.moves = 0
+				var keys []string
+				for it.Next() {
+					keys = append(keys, string(it.Key()))
+				}
+				Expect(it.Err()).To(NotExist)
+				Expect(it.Close()).To(NotExist)
+				return strings.Join(keys, ",")
+			}
+
+			It("NewDatabase", func() {
+				Expect(Info().RegisterKVEngine("step", func() KVEngine {
+					step = &stepEngine{orderedEngine: NewOrderedKVEngine(nil)().(*orderedEngine)}
+					return step
+				})).To(NotExist)
+
+				var err error
+				db, err = OpenDatabase("", WithConfig(Config{KVEngine: "step"}))
+				Expect(err).To(NotExist)
+				for _, k := range []string{"a1", "a2", "b1", "b2", "c1", "c2"} {
+					Expect(db.Store([]byte(k), []byte("v"+k))).To(NotExist)
+				}
+			})
+			It("Database.Range", func() {
+				// The walk seeks to b1 and stops at c1
+				Expect(walk(db.Range([]byte("b"), []byte("c1"), nil))).To(Equal, "b1,b2")
+				Expect(step.moves).To(Equal, 3)
+				Expect(walk(db.Range([]byte("a2"), []byte("c1"), &IteratorOptions{Reverse: true}))).To(Equal, "b2,b1,a2")
+				Expect(step.moves).To(Equal, 5)
+				Expect(walk(db.Range([]byte("d"), nil, nil))).To(Equal, "")
+			})
+			It("Database.Prefix", func() {
+				Expect(walk(db.Prefix([]byte("c")))).To(Equal, "c1,c2")
+				Expect(step.moves).To(Equal, 3)
+			})
+			It("Database.All.Err", func() {
+				Expect(db.Store([]byte("bad"), nil)).To(NotExist)
+				entries, errf := db.All()
+				var keys []string
+				for k := range entries {
+					keys = append(keys, string(k))
+				}
+				Expect(strings.Join(keys, ",")).To(Equal, "a1,a2,b1,b2")
+				Expect(strings.Contains(errf().Error(), "step: bad value")).To(Equal, true)
+			})
+			It("Database.Close", func() {
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
 }
 
-func BenchmarkMemStore(b *testing.B) {
-	b.StopTimer()
-	db, _ := NewDatabase("")
-	b.StartTimer()
-	for i := 0; i < b.N; i++ {
-		db.Store([]byte(fmt.Sprintf("%d", i)), []byte("abcdefghijklmnopabcdefghijklmnopabcdefghijklmnopabcdefghijklmnop"))
+// stepEngine is an ordered engine counting the cursor moves, the value of the key "bad" cannot be read.
+type stepEngine struct {
+	*orderedEngine
+	moves int
+}
+
+type stepCursor struct {
+	KVCursor
+	e *stepEngine
+}
+
+func (e *stepEngine) Cursor() KVCursor {
+	return &stepCursor{KVCursor: e.orderedEngine.Cursor(), e: e}
+}
+
+func (c *stepCursor) Seek(key []byte, match SeekMatch) error {
+	c.e.moves++
+	return c.KVCursor.Seek(key, match)
+}
+
+func (c *stepCursor) First() error { c.e.moves++; return c.KVCursor.First() }
+func (c *stepCursor) Last() error  { c.e.moves++; return c.KVCursor.Last() }
+func (c *stepCursor) Next() error  { c.e.moves++; return c.KVCursor.Next() }
+func (c *stepCursor) Prev() error  { c.e.moves++; return c.KVCursor.Prev() }
+
+func (c *stepCursor) Value() ([]byte, error) {
+	if k, _ := c.Key(); string(k) == "bad" {
+		return nil, errors.New("step: bad value")
 	}
+	return c.KVCursor.Value()
 }
 
-func BenchmarkMemFetch(b *testing.B) {
-	b.StopTimer()
-	db, _ := NewDatabase("")
-	for i := 0; i < b.N; i++ {
-		db.Store([]byte(fmt.Sprintf("%d", i)), []byte("abcdefghijklmnopabcdefghijklmnopabcdefghijklmnopabcdefghijklmnop"))
+func TestForeignFunction(t *testing.T) {
+	var db *Database
+	var vm *VM
+
+	Describe(t, "Normal", func() {
+		Context("Function", func() {
+			It("Database.Compile", func() {
+				var err error
+				db, err = NewDatabase("")
+				Expect(err).To(NotExist)
+				vm = NewVM()
+				_, err = db.Compile(`$sum = go_add(2, 3); $upper = go_upper('abc'); $failed = go_fail(); $after = true;`, vm)
+				Expect(err).To(NotExist)
+			})
+			It("VM.RegisterFunc", func() {
+				Expect(vm.RegisterFunc("go_add", func(ctx *FuncContext, args []Value) (Value, error) {
+					Expect(ctx.FunctionName()).To(Equal, "go_add")
+					return ctx.NewValue(args[0].Int64() + args[1].Int64())
+				})).To(NotExist)
+				Expect(vm.RegisterFunc("go_upper", func(ctx *FuncContext, args []Value) (Value, error) {
+					Expect(args[0].IsString()).To(Equal, true)
+					return Value{}, ctx.ResultString(strings.ToUpper(args[0].String()))
+				})).To(NotExist)
+				Expect(vm.RegisterFunc("go_fail", func(ctx *FuncContext, args []Value) (Value, error) {
+					return Value{}, errors.New("fail")
+				})).To(NotExist)
+				Expect(vm.RegisterFunc(" ", nil)).To(Equal, error(ErrInvalid))
+			})
+			It("VM.Execute", func() {
+				Expect(vm.Execute()).To(Equal, 0)
+				sum, _ := vm.ExtractInt("sum")
+				Expect(sum).To(Equal, 5)
+				upper, _ := vm.ExtractString("upper")
+				Expect(upper).To(Equal, "ABC")
+				after, _ := vm.ExtractBool("after")
+				Expect(after).To(Equal, true)
+			})
+			It("VM.UnregisterFunc", func() {
+				Expect(vm.UnregisterFunc("go_fail")).To(NotExist)
+				Expect(len(vm.funcs)).To(Equal, 2)
+			})
+			It("VM.Run.Abort", func() {
+				abort := NewVM()
+				defer abort.Close()
+				_, err := db.Compile(`go_stop($panic); $after = true;`, abort)
+				Expect(err).To(NotExist)
+				Expect(abort.RegisterFunc("go_stop", func(ctx *FuncContext, args []Value) (Value, error) {
+					if args[0].Bool() {
+						panic("boom")
+					}
+					return Value{}, fmt.Errorf("stop: %w", ErrAbort)
+				})).To(NotExist)
+
+				err = abort.Run()
+				Expect(errors.Is(err, ErrAborted)).To(Equal, true)
+				Expect(errors.Is(err, ErrAbort)).To(Equal, true)
+				var ce *CallbackError
+				Expect(errors.As(err, &ce)).To(Equal, true)
+				Expect(ce.Name).To(Equal, "go_stop")
+				Expect(err.Error()).To(Equal, "Execute: Execution aborted: go_stop: stop: Another thread have released this instance")
+				_, err = abort.Extract("after")
+				Expect(errors.Is(err, ErrNotFound)).To(Equal, true)
+
+				Expect(abort.Reset()).To(NotExist)
+				Expect(abort.SetVar("panic", true)).To(NotExist)
+				err = abort.Run()
+				Expect(errors.As(err, &ce)).To(Equal, true)
+				Expect(errors.Is(err, ErrAborted)).To(Equal, true)
+				Expect(ce.Err.Error()).To(Equal, "panic: boom")
+			})
+			It("Database.Close", func() {
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+}
+
+func TestSetVar(t *testing.T) {
+	var db *Database
+	var vm *VM
+
+	type user struct {
+		Name  string   `json:"name"`
+		Age   int      `json:"age"`
+		Tags  []string `json:"tags"`
+		Email string   `json:"email,omitempty"`
+	}
+
+	Describe(t, "Normal", func() {
+		Context("Variables", func() {
+			It("Database.Compile", func() {
+				var err error
+				db, err = NewDatabase("")
+				Expect(err).To(NotExist)
+				vm = NewVM()
+				_, err = db.Compile(`$n = $num + 1; $s = $str; $l = count($list); $m = $map.b; $u = $user.name; $t = $user.tags[1]; $j = json_encode($user);`, vm)
+				Expect(err).To(NotExist)
+			})
+			It("VM.SetVar", func() {
+				Expect(vm.SetVar("num", 41)).To(NotExist)
+				Expect(vm.SetVar("$str", "hello")).To(NotExist)
+				Expect(vm.SetVar("list", []int{1, 2, 3})).To(NotExist)
+				Expect(vm.SetVar("map", map[string]interface{}{"a": 1, "b": "two"})).To(NotExist)
+				Expect(vm.SetVar("user", &user{Name: "bob", Age: 3, Tags: []string{"x", "y"}})).To(NotExist)
+				Expect(vm.SetVar("", 1)).To(Equal, error(ErrInvalid))
+				Expect(errors.Is(vm.SetVar("ch", make(chan int)), ErrUnsupportedType)).To(Equal, true)
+				Expect(errors.Is(vm.SetVar("big", uint64(1<<63)), ErrUnsupportedType)).To(Equal, true)
+				Expect(errors.Is(vm.SetVar("big", []uint{1 << 63}), ErrUnsupportedType)).To(Equal, true)
+				Expect(errors.Is(vm.SetVar("big", struct{ N uint64 }{1 << 63}), ErrUnsupportedType)).To(Equal, true)
+			})
+			It("VM.Execute", func() {
+				Expect(vm.Execute()).To(Equal, 0)
+				n, _ := vm.ExtractInt("n")
+				Expect(n).To(Equal, 42)
+				s, _ := vm.ExtractString("s")
+				Expect(s).To(Equal, "hello")
+				l, _ := vm.ExtractInt("l")
+				Expect(l).To(Equal, 3)
+				m, _ := vm.ExtractString("m")
+				Expect(m).To(Equal, "two")
+				u, _ := vm.ExtractString("u")
+				Expect(u).To(Equal, "bob")
+				tag, _ := vm.ExtractString("t")
+				Expect(tag).To(Equal, "y")
+				j, _ := vm.ExtractString("j")
+				Expect(j).To(Equal, `{"name":"bob","age":3,"tags":["x","y"]}`)
+			})
+			It("Database.Close", func() {
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+}
+
+func TestExtract(t *testing.T) {
+	var db *Database
+	var vm *VM
+
+	type record struct {
+		ID   int64  `json:"__id"`
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+
+	Describe(t, "Normal", func() {
+		Context("Extract", func() {
+			It("Database.Compile", func() {
+				var err error
+				db, err = NewDatabase("")
+				Expect(err).To(NotExist)
+				vm = NewVM()
+				_, err = db.Compile(`db_create('users');
+					db_store('users', [{name: 'bob', age: 3}, {name: 'alice', age: 4}]);
+					$all = db_fetch_all('users');
+					$list = [1, 2.5, true, null, 'x'];
+					$obj = {name: 'carol', tags: ['a']};`, vm)
+				Expect(err).To(NotExist)
+				Expect(vm.Execute()).To(Equal, 0)
+			})
+			It("VM.Extract", func() {
+				v, err := vm.Extract("list")
+				Expect(err).To(NotExist)
+				Expect(v.IsArray()).To(Equal, true)
+				Expect(v.IsObject()).To(Equal, false)
+				Expect(v.Len()).To(Equal, 5)
+				Expect(v.Get("4").IsNull()).To(Equal, true)
+				Expect(v.Interface()).To(Equal, []interface{}{int64(1), 2.5, true, nil, "x"})
+				n := 0
+				Expect(v.Walk(func(key, value Value) bool {
+					n++
+					return n < 2
+				})).To(NotExist)
+				Expect(n).To(Equal, 2)
+				v, err = vm.Extract("obj")
+				Expect(err).To(NotExist)
+				Expect(v.IsObject()).To(Equal, true)
+				Expect(v.Get("name").String()).To(Equal, "carol")
+				Expect(v.Interface()).To(Equal, map[string]interface{}{"name": "carol", "tags": []interface{}{"a"}})
+				_, err = vm.Extract("missing")
+				Expect(errors.Is(err, ErrNotFound)).To(Equal, true)
+			})
+			It("VM.ExtractInto", func() {
+				var maps []map[string]interface{}
+				Expect(vm.ExtractInto("all", &maps)).To(NotExist)
+				Expect(len(maps)).To(Equal, 2)
+				Expect(maps[0]["name"]).To(Equal, "bob")
+				var records []record
+				Expect(vm.ExtractInto("$all", &records)).To(NotExist)
+				Expect(records[1]).To(Equal, record{ID: 1, Name: "alice", Age: 4})
+			})
+			It("Database.Close", func() {
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+}
+
+func TestProgram(t *testing.T) {
+	var db *Database
+	var prog *Program
+
+	Describe(t, "Normal", func() {
+		Context("Lifecycle", func() {
+			It("VM.Reset", func() {
+				var err error
+				db, err = NewDatabase("")
+				Expect(err).To(NotExist)
+				vm := NewVM()
+				_, err = db.Compile(`if (!isset($n)) { $n = 0; } $n++; print $n;`, vm)
+				Expect(err).To(NotExist)
+				for i := 0; i < 3; i++ {
+					Expect(vm.Execute()).To(Equal, 0)
+					Expect(vm.Result()).To(Equal, "1")
+					Expect(vm.Reset()).To(NotExist)
+				}
+				Expect(vm.Close()).To(NotExist)
+				Expect(vm.Close()).To(NotExist)
+				Expect(vm.Reset()).To(Exist)
+			})
+			It("Database.Prepare", func() {
+				var err error
+				prog, err = db.Prepare(`$total = $a + $b;`)
+				Expect(err).To(NotExist)
+				again, err := db.Prepare(`$total = $a + $b;`)
+				Expect(err).To(NotExist)
+				Expect(again == prog).To(Equal, true)
+				_, err = db.Prepare(`$total = ;`)
+				Expect(errors.Is(err, ErrCompile)).To(Equal, true)
+			})
+			It("Program.Acquire", func() {
+				done := make(chan int64)
+				for i := 0; i < 4; i++ {
+					go func(i int) {
+						vm, err := prog.Acquire()
+						if err != nil {
+							done <- -1
+							return
+						}
+						defer prog.Release(vm)
+						vm.SetVar("a", i)
+						vm.SetVar("b", 10)
+						vm.Execute()
+						total, _ := vm.ExtractInt64("total")
+						done <- total
+					}(i)
+				}
+				var sum int64
+				for i := 0; i < 4; i++ {
+					sum += <-done
+				}
+				Expect(sum).To(Equal, int64(46))
+			})
+			It("Program.Release.Foreign", func() {
+				other, err := db.Prepare(`$total = $a * $b;`)
+				Expect(err).To(NotExist)
+				vm, err := other.Acquire()
+				Expect(err).To(NotExist)
+				Expect(prog.Release(vm)).To(Equal, error(ErrInvalid))
+				Expect(prog.Release(NewVM())).To(Equal, error(ErrInvalid))
+				Expect(other.Release(vm)).To(NotExist)
+				Expect(other.Release(vm)).To(Equal, error(ErrInvalid))
+				again, err := other.Acquire()
+				Expect(err).To(NotExist)
+				Expect(again == vm).To(Equal, true)
+				_, err = other.Acquire()
+				Expect(err).To(NotExist)
+			})
+			It("Program.Release.State", func() {
+				state, err := db.Prepare(`$seen = is_null($secret) ? 'none' : $secret; $c = defined('TENANT') ? TENANT : 'none';`)
+				Expect(err).To(NotExist)
+				vm, err := state.Acquire()
+				Expect(err).To(NotExist)
+				Expect(vm.SetVar("secret", "s3cr3t")).To(NotExist)
+				Expect(vm.DefineConstant("TENANT", func() interface{} { return "acme" })).To(NotExist)
+				Expect(vm.Run()).To(NotExist)
+				seen, _ := vm.ExtractString("seen")
+				Expect(seen).To(Equal, "s3cr3t")
+				c, _ := vm.ExtractString("c")
+				Expect(c).To(Equal, "acme")
+				Expect(state.Release(vm)).To(NotExist)
+
+				// The next caller gets the VM without the state of the previous one
+				vm, err = state.Acquire()
+				Expect(err).To(NotExist)
+				Expect(len(vm.consts)).To(Equal, 0)
+				Expect(vm.Run()).To(NotExist)
+				seen, _ = vm.ExtractString("seen")
+				Expect(seen).To(Equal, "none")
+				c, _ = vm.ExtractString("c")
+				Expect(c).To(Equal, "none")
+
+				// The output cannot be restored, the VM is closed instead of kept
+				Expect(vm.SetOutput(ioutil.Discard)).To(NotExist)
+				Expect(state.Release(vm)).To(NotExist)
+				Expect(vm.vm == nil).To(Equal, true)
+				Expect(len(state.idle)).To(Equal, 0)
+			})
+			It("Database.Prepare.Evict", func() {
+				first, err := db.Prepare(`$n = 0;`)
+				Expect(err).To(NotExist)
+				for i := 1; i <= 64; i++ {
+					_, err := db.Prepare(fmt.Sprintf(`$n = %d;`, i))
+					Expect(err).To(NotExist)
+				}
+				again, err := db.Prepare(`$n = 0;`)
+				Expect(err).To(NotExist)
+				Expect(again == first).To(Equal, false)
+
+				// An evicted program remains usable
+				vm, err := first.Acquire()
+				Expect(err).To(NotExist)
+				Expect(vm.Run()).To(NotExist)
+				Expect(first.Release(vm)).To(NotExist)
+			})
+			It("Database.Close", func() {
+				vm, err := prog.Acquire()
+				Expect(err).To(NotExist)
+				Expect(db.Close()).To(NotExist)
+				Expect(prog.Release(vm)).To(NotExist)
+			})
+		})
+	})
+}
+
+func TestOutput(t *testing.T) {
+	var db *Database
+	var vm *VM
+
+	Describe(t, "Normal", func() {
+		Context("Output", func() {
+			It("VM.SetOutput", func() {
+				var err error
+				db, err = NewDatabase("")
+				Expect(err).To(NotExist)
+				vm = NewVM()
+				_, err = db.Compile(`for ($i = 0; $i < 3; $i++) { print "line $i\n"; } $done = true;`, vm)
+				Expect(err).To(NotExist)
+				var buf bytes.Buffer
+				Expect(vm.SetOutput(&buf)).To(NotExist)
+				Expect(vm.Execute()).To(Equal, 0)
+				Expect(buf.String()).To(Equal, "line 0\nline 1\nline 2\n")
+				Expect(vm.Result()).To(Equal, "")
+				Expect(vm.OutputErr()).To(NotExist)
+			})
+			It("VM.SetOutput.Error", func() {
+				Expect(vm.Close()).To(NotExist)
+				_, err := db.Compile(`print "line\n"; $done = true;`, vm)
+				Expect(err).To(NotExist)
+				Expect(vm.SetOutput(failWriter{})).To(NotExist)
+				vm.Execute()
+				Expect(vm.OutputErr()).To(Exist)
+				done, _ := vm.ExtractBool("done")
+				Expect(done).To(Equal, false)
+			})
+			It("VM.Close", func() {
+				Expect(vm.Close()).To(NotExist)
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+}
+
+func TestExecuteContext(t *testing.T) {
+	var db *Database
+	var path string
+
+	Describe(t, "Normal", func() {
+		Context("Abort", func() {
+			It("VM.ExecuteContext", func() {
+				f, err := ioutil.TempFile("", "sample.db")
+				Expect(err).To(NotExist)
+				path = f.Name()
+				db, err = NewDatabase(path)
+				Expect(err).To(NotExist)
+				vm := NewVM()
+				_, err = db.Compile(`$n = 1 + 2;`, vm)
+				Expect(err).To(NotExist)
+				Expect(vm.ExecuteContext(context.Background())).To(NotExist)
+				n, _ := vm.ExtractInt("n")
+				Expect(n).To(Equal, 3)
+				Expect(vm.Close()).To(NotExist)
+			})
+			It("VM.ExecuteContext.Timeout", func() {
+				vm := NewVM()
+				_, err := db.Compile(`db_create('users'); db_store('users', {name: 'bob'}); while (true) { $i++; }`, vm)
+				Expect(err).To(NotExist)
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+				err = vm.ExecuteContext(ctx)
+				Expect(errors.Is(err, ErrAborted)).To(Equal, true)
+				Expect(ctx.Err()).To(Equal, context.DeadlineExceeded)
+				Expect(vm.Close()).To(NotExist)
+			})
+			It("VM.ExecuteContext.Rollback", func() {
+				// The writes of the aborted program are rolled back
+				vm := NewVM()
+				_, err := db.Compile(`$exists = db_exists('users');`, vm)
+				Expect(err).To(NotExist)
+				Expect(vm.ExecuteContext(context.Background())).To(NotExist)
+				exists, _ := vm.ExtractBool("exists")
+				Expect(exists).To(Equal, false)
+				Expect(vm.Close()).To(NotExist)
+
+				// Nor are they committed when the database is closed
+				Expect(db.Close()).To(NotExist)
+				db, err = NewDatabase(path)
+				Expect(err).To(NotExist)
+				vm = NewVM()
+				_, err = db.Compile(`$exists = db_exists('users');`, vm)
+				Expect(err).To(NotExist)
+				Expect(vm.ExecuteContext(context.Background())).To(NotExist)
+				exists, _ = vm.ExtractBool("exists")
+				Expect(exists).To(Equal, false)
+				Expect(vm.Close()).To(NotExist)
+			})
+			It("VM.ExecuteContext.Tx", func() {
+				// The writes of a transaction open before the program are left to the caller
+				Expect(db.Store([]byte("key"), []byte("value"))).To(NotExist)
+				vm := NewVM()
+				_, err := db.Compile(`db_create('orders'); while (true) { $i++; }`, vm)
+				Expect(err).To(NotExist)
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+				Expect(errors.Is(vm.ExecuteContext(ctx), ErrAborted)).To(Equal, true)
+				Expect(vm.Close()).To(NotExist)
+				v, err := db.Fetch([]byte("key"))
+				Expect(err).To(NotExist)
+				Expect(string(v)).To(Equal, "value")
+
+				Expect(db.Rollback()).To(NotExist)
+				_, err = db.Fetch([]byte("key"))
+				Expect(errors.Is(err, ErrNotFound)).To(Equal, true)
+				vm = NewVM()
+				_, err = db.Compile(`$exists = db_exists('orders');`, vm)
+				Expect(err).To(NotExist)
+				Expect(vm.ExecuteContext(context.Background())).To(NotExist)
+				exists, _ := vm.ExtractBool("exists")
+				Expect(exists).To(Equal, false)
+				Expect(vm.Close()).To(NotExist)
+			})
+			It("VM.ExecuteContext.Canceled", func() {
+				vm := NewVM()
+				_, err := db.Compile(`$n = 1;`, vm)
+				Expect(err).To(NotExist)
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				Expect(errors.Is(vm.ExecuteContext(ctx), ErrAborted)).To(Equal, true)
+				Expect(vm.Close()).To(NotExist)
+			})
+			It("Database.Close", func() {
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+}
+
+func BenchmarkFileStore(b *testing.B) {
+	b.StopTimer()
+	f, err := ioutil.TempFile("", "sample.db")
+	if err != nil {
+		panic(err)
+	}
+	db, _ := NewDatabase(f.Name())
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		db.Store([]byte(fmt.Sprintf("%d", i)), []byte("abcdefghijklmnopabcdefghijklmnopabcdefghijklmnopabcdefghijklmnop"))
+	}
+}
+
+func BenchmarkFileFetch(b *testing.B) {
+	b.StopTimer()
+	f, err := ioutil.TempFile("", "sample.db")
+	if err != nil {
+		panic(err)
+	}
+	db, _ := NewDatabase(f.Name())
+	for i := 0; i < b.N; i++ {
+		db.Store([]byte(fmt.Sprintf("%d", i)), []byte("abcdefghijklmnopabcdefghijklmnopabcdefghijklmnopabcdefghijklmnop"))
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = db.Fetch([]byte(fmt.Sprintf("%d", i)))
+	}
+}
+
+func BenchmarkMemStore(b *testing.B) {
+	b.StopTimer()
+	db, _ := NewDatabase("")
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		db.Store([]byte(fmt.Sprintf("%d", i)), []byte("abcdefghijklmnopabcdefghijklmnopabcdefghijklmnopabcdefghijklmnop"))
+	}
+}
+
+func BenchmarkMemFetch(b *testing.B) {
+	b.StopTimer()
+	db, _ := NewDatabase("")
+	for i := 0; i < b.N; i++ {
+		db.Store([]byte(fmt.Sprintf("%d", i)), []byte("abcdefghijklmnopabcdefghijklmnopabcdefghijklmnopabcdefghijklmnop"))
 	}
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
 		_, _ = db.Fetch([]byte(fmt.Sprintf("%d", i)))
 	}
 }
+
+func TestCompileFile(t *testing.T) {
+	var db *Database
+
+	Describe(t, "Normal", func() {
+		Context("Include", func() {
+			It("Database.CompileFile", func() {
+				var err error
+				db, err = NewDatabase("")
+				Expect(err).To(NotExist)
+				dir, err := ioutil.TempDir("", "unqlitego")
+				Expect(err).To(NotExist)
+				defer os.RemoveAll(dir)
+				lib := filepath.Join(dir, "lib")
+				Expect(os.Mkdir(lib, 0755)).To(NotExist)
+				Expect(ioutil.WriteFile(filepath.Join(dir, "main.jx9"), []byte(`include 'util.jx9'; include 'greet.jx9'; $msg = greet('file');`), 0644)).To(NotExist)
+				Expect(ioutil.WriteFile(filepath.Join(dir, "util.jx9"), []byte(`function greet($n) { return prefix() .. $n; }`), 0644)).To(NotExist)
+				Expect(ioutil.WriteFile(filepath.Join(lib, "greet.jx9"), []byte(`function prefix() { return 'hello '; }`), 0644)).To(NotExist)
+				vm := NewVM()
+				defer vm.Close()
+				_, err = db.CompileFile(filepath.Join(dir, "main.jx9"), vm)
+				Expect(err).To(NotExist)
+				Expect(vm.AddImportPath(lib)).To(NotExist)
+				Expect(vm.Execute()).To(Equal, 0)
+				msg, err := vm.Extract("msg")
+				Expect(err).To(NotExist)
+				Expect(msg.String()).To(Equal, "hello file")
+			})
+			It("Database.CompileFS", func() {
+				fsys := fstest.MapFS{
+					"scripts/main.jx9":     {Data: []byte(`include 'fs://lib/util.jx9'; $msg = greet('fs');`)},
+					"scripts/lib/util.jx9": {Data: []byte(`function greet($n) { return 'hello ' .. $n; }`)},
+				}
+				vm := NewVM()
+				defer vm.Close()
+				_, err := db.CompileFS(fsys, "scripts/main.jx9", vm)
+				Expect(err).To(NotExist)
+				Expect(vm.Execute()).To(Equal, 0)
+				msg, err := vm.Extract("msg")
+				Expect(err).To(NotExist)
+				Expect(msg.String()).To(Equal, "hello fs")
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+
+	Describe(t, "Error", func() {
+		Context("Missing", func() {
+			It("Database.CompileFile", func() {
+				db, err := NewDatabase("")
+				Expect(err).To(NotExist)
+				defer db.Close()
+				_, err = db.CompileFile(filepath.Join(os.TempDir(), "unqlitego-missing.jx9"), NewVM())
+				Expect(err).To(Exist)
+				_, err = db.CompileFS(fstest.MapFS{}, "main.jx9", NewVM())
+				Expect(err).To(Exist)
+			})
+		})
+	})
+}
+
+func TestDiagnostics(t *testing.T) {
+	var db *Database
+
+	Describe(t, "Normal", func() {
+		Context("Compile", func() {
+			It("CompileError", func() {
+				var err error
+				db, err = NewDatabase("")
+				Expect(err).To(NotExist)
+				_, err = db.Compile("$a = 1;\n$b = ;\nfunction f( { }", NewVM())
+				var ce *CompileError
+				Expect(errors.As(err, &ce)).To(Equal, true)
+				Expect(len(ce.Diagnostics)).To(Equal, 2)
+				Expect(ce.Diagnostics[0]).To(Equal, Diagnostic{Line: 2, Severity: SeverityError, Message: "'=': Missing/Invalid operand"})
+				Expect(ce.Diagnostics[1].Line).To(Equal, 3)
+				Expect(ce.Diagnostics[1].String()).To(Equal, "3 Error: Missing ')' after function 'f' signature")
+			})
+		})
+		Context("Runtime", func() {
+			It("VM.EnableErrorReport", func() {
+				vm := NewVM()
+				defer vm.Close()
+				_, err := db.Compile(`$x = foo(1); print 'ok';`, vm)
+				Expect(err).To(NotExist)
+				Expect(vm.EnableErrorReport()).To(NotExist)
+				Expect(vm.Run()).To(NotExist)
+				Expect(vm.Result()).To(Equal, "ok")
+				diags := vm.Diagnostics()
+				Expect(len(diags)).To(Equal, 1)
+				Expect(diags[0].Severity).To(Equal, SeverityWarning)
+			})
+			It("RuntimeError", func() {
+				vm := NewVM()
+				defer vm.Close()
+				_, err := db.Compile(`$c = 1 % 0; print 'done';`, vm)
+				Expect(err).To(NotExist)
+				Expect(vm.EnableErrorReport()).To(NotExist)
+				err = vm.Run()
+				var re *RuntimeError
+				Expect(errors.As(err, &re)).To(Equal, true)
+				Expect(re.Diagnostics[0].Severity).To(Equal, SeverityError)
+				Expect(strings.HasPrefix(re.Diagnostics[0].Message, "Division by zero")).To(Equal, true)
+				Expect(vm.Result()).To(Equal, "done")
+				Expect(vm.Reset()).To(NotExist)
+				Expect(len(vm.Diagnostics())).To(Equal, 0)
+			})
+			It("FuncContext.ThrowError", func() {
+				vm := NewVM()
+				defer vm.Close()
+				_, err := db.Compile(`fail();`, vm)
+				Expect(err).To(NotExist)
+				Expect(vm.RegisterFunc("fail", func(ctx *FuncContext, args []Value) (Value, error) {
+					return Value{}, errors.New("boom")
+				})).To(NotExist)
+				Expect(vm.EnableErrorReport()).To(NotExist)
+				err = vm.Run()
+				Expect(err).To(Exist)
+				Expect(err.Error()).To(Equal, "Execute: Error: fail(): boom")
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+
+	Describe(t, "Disabled", func() {
+		Context("Runtime", func() {
+			It("VM.Execute", func() {
+				db, err := NewDatabase("")
+				Expect(err).To(NotExist)
+				defer db.Close()
+				vm := NewVM()
+				defer vm.Close()
+				_, err = db.Compile(`$c = 1 % 0; print 'done';`, vm)
+				Expect(err).To(NotExist)
+				Expect(vm.Run()).To(NotExist)
+				Expect(vm.Result()).To(Equal, "done")
+				Expect(vm.Diagnostics() == nil).To(Equal, true)
+			})
+		})
+	})
+}
+
+func TestExecuteArgs(t *testing.T) {
+	var db *Database
+
+	Describe(t, "Normal", func() {
+		Context("Return", func() {
+			It("VM.ExecuteArgs", func() {
+				var err error
+				db, err = NewDatabase("")
+				Expect(err).To(NotExist)
+				vm := NewVM()
+				defer vm.Close()
+				_, err = db.Compile(`return {count: count($argv), first: $argv[0], ok: true};`, vm)
+				Expect(err).To(NotExist)
+				v, err := vm.ExecuteArgs("a", "b")
+				Expect(err).To(NotExist)
+				Expect(v.Interface()).To(Equal, map[string]interface{}{"count": int64(2), "first": "a", "ok": true})
+				Expect(vm.Reset()).To(NotExist)
+				v, err = vm.ExecuteArgs("c")
+				Expect(err).To(NotExist)
+				Expect(v.Get("count").Int()).To(Equal, 1)
+				Expect(v.Get("first").String()).To(Equal, "c")
+			})
+			It("VM.ExecuteArgs.NoReturn", func() {
+				vm := NewVM()
+				defer vm.Close()
+				_, err := db.Compile(`$a = 1;`, vm)
+				Expect(err).To(NotExist)
+				v, err := vm.ExecuteArgs()
+				Expect(err).To(NotExist)
+				Expect(v.IsNull()).To(Equal, true)
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+}
+
+func TestDefineConstant(t *testing.T) {
+	var db *Database
+
+	Describe(t, "Normal", func() {
+		Context("Expand", func() {
+			It("VM.DefineConstant", func() {
+				var err error
+				db, err = NewDatabase("")
+				Expect(err).To(NotExist)
+				vm := NewVM()
+				defer vm.Close()
+				_, err = db.Compile(`$t = __TENANT__; $a = __COUNTER__; $b = __COUNTER__; $v = __VERSION__;`, vm)
+				Expect(err).To(NotExist)
+				n := 0
+				Expect(vm.DefineConstant("__TENANT__", func() interface{} { return "acme" })).To(NotExist)
+				Expect(vm.DefineConstant("__COUNTER__", func() interface{} { n++; return n })).To(NotExist)
+				Expect(vm.DefineConstant("__VERSION__", func() interface{} { return bytes.NewBufferString("1.2.3") })).To(NotExist)
+				Expect(vm.Run()).To(NotExist)
+				for name, want := range map[string]interface{}{"t": "acme", "a": int64(1), "b": int64(2), "v": "1.2.3"} {
+					v, err := vm.Extract(name)
+					Expect(err).To(NotExist)
+					Expect(v.Interface()).To(Equal, want)
+				}
+			})
+			It("VM.DeleteConstant", func() {
+				vm := NewVM()
+				defer vm.Close()
+				_, err := db.Compile(`$t = __TENANT__;`, vm)
+				Expect(err).To(NotExist)
+				Expect(vm.DefineConstant("__TENANT__", func() interface{} { return "acme" })).To(NotExist)
+				Expect(vm.DeleteConstant("__TENANT__")).To(NotExist)
+				Expect(vm.Run()).To(NotExist)
+				v, err := vm.Extract("t")
+				Expect(err).To(NotExist)
+				Expect(v.String()).To(Equal, "__TENANT__")
+				Expect(vm.DefineConstant("", func() interface{} { return nil })).To(Exist)
+			})
+			It("VM.DefineConstant.Panic", func() {
+				vm := NewVM()
+				defer vm.Close()
+				_, err := db.Compile(`$t = __TENANT__; $after = true;`, vm)
+				Expect(err).To(NotExist)
+				Expect(vm.DefineConstant("__TENANT__", func() interface{} { panic("no tenant") })).To(NotExist)
+				err = vm.Run()
+				Expect(errors.Is(err, ErrAborted)).To(Equal, true)
+				var ce *CallbackError
+				Expect(errors.As(err, &ce)).To(Equal, true)
+				Expect(ce.Name).To(Equal, "__TENANT__")
+				Expect(ce.Err.Error()).To(Equal, "panic: no tenant")
+				_, err = vm.Extract("after")
+				Expect(errors.Is(err, ErrNotFound)).To(Equal, true)
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+}
+
+func TestDump(t *testing.T) {
+	Describe(t, "Normal", func() {
+		Context("Disassembly", func() {
+			It("VM.Dump", func() {
+				db, err := NewDatabase("")
+				Expect(err).To(NotExist)
+				defer db.Close()
+				vm := NewVM()
+				defer vm.Close()
+				_, err = db.Compile(`$a = 1 + 2; print $a;`, vm)
+				Expect(err).To(NotExist)
+				var buf bytes.Buffer
+				Expect(vm.Dump(&buf)).To(NotExist)
+				Expect(strings.Contains(buf.String(), "ADD")).To(Equal, true)
+				Expect(strings.Contains(buf.String(), "CONSUME")).To(Equal, true)
+				Expect(strings.Contains(buf.String(), "DONE")).To(Equal, true)
+				Expect(NewVM().Dump(&buf)).To(Exist)
+			})
+		})
+	})
+}
+
+func TestHTTPRequest(t *testing.T) {
+	var db *Database
+
+	Describe(t, "Normal", func() {
+		Context("Request", func() {
+			It("VM.SetHTTPRequest", func() {
+				var err error
+				db, err = NewDatabase("")
+				Expect(err).To(NotExist)
+				vm := NewVM()
+				defer vm.Close()
+				_, err = db.Compile(`return {
+					get: $_GET, post: $_POST, cookie: $_COOKIE['session'],
+					method: $_SERVER['REQUEST_METHOD'], uri: $_SERVER['REQUEST_URI'], host: $_SERVER['HTTP_HOST'],
+					tenant: $_SERVER['TENANT'], stage: $_ENV['STAGE']
+				};`, vm)
+				Expect(err).To(NotExist)
+
+				form := url.Values{"name": {"gopher"}}
+				r := httptest.NewRequest(http.MethodPost, "http://example.com/users?id=7", strings.NewReader(form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				r.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+				Expect(vm.SetHTTPRequest(r)).To(NotExist)
+				Expect(vm.SetServerAttr("TENANT", "acme")).To(NotExist)
+				Expect(vm.SetEnv("STAGE", "test")).To(NotExist)
+				v, err := vm.ExecuteArgs()
+				Expect(err).To(NotExist)
+				Expect(v.Get("get").Interface()).To(Equal, map[string]interface{}{"id": "7"})
+				Expect(v.Get("post").Interface()).To(Equal, map[string]interface{}{"name": "gopher"})
+				Expect(v.Get("cookie").String()).To(Equal, "abc")
+				Expect(v.Get("method").String()).To(Equal, "POST")
+				Expect(v.Get("uri").String()).To(Equal, "/users?id=7")
+				Expect(v.Get("host").String()).To(Equal, "example.com")
+				Expect(v.Get("tenant").String()).To(Equal, "acme")
+				Expect(v.Get("stage").String()).To(Equal, "test")
+				body, err := ioutil.ReadAll(r.Body)
+				Expect(err).To(NotExist)
+				Expect(string(body)).To(Equal, form.Encode())
+
+				Expect(vm.Reset()).To(NotExist)
+				Expect(vm.SetHTTPRequest(httptest.NewRequest(http.MethodDelete, "/users?id=8", nil))).To(NotExist)
+				v, err = vm.ExecuteArgs()
+				Expect(err).To(NotExist)
+				Expect(v.Get("get").Interface()).To(Equal, map[string]interface{}{"id": "8"})
+				Expect(v.Get("post").Len()).To(Equal, 0)
+				Expect(v.Get("cookie").IsNull()).To(Equal, true)
+				Expect(v.Get("method").String()).To(Equal, "DELETE")
+				Expect(vm.SetEnv("", "x")).To(Exist)
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+}
+
+// memVFS is an in-memory VFS recording the files opened through it.
+type memVFS struct {
+	files  map[string]*memFile
+	opened []string
+}
+
+type memFile struct {
+	data []byte
+
+	// Lock and Unlock calls
+	locks []string
+}
+
+func (v *memVFS) Open(name string, mode Mode) (File, error) {
+	f, ok := v.files[name]
+	if !ok {
+		if !mode.Has(ModeCreate) {
+			return nil, ErrIO
+		}
+		f = &memFile{}
+		v.files[name] = f
+	}
+	v.opened = append(v.opened, name)
+
+	return f, nil
+}
+
+func (v *memVFS) Delete(name string, syncDir bool) error {
+	delete(v.files, name)
+	return nil
+}
+
+func (v *memVFS) Access(name string, flag AccessFlag) (bool, error) {
+	f, ok := v.files[name]
+	return ok && (flag != AccessExists || len(f.data) > 0), nil
+}
+
+func (v *memVFS) FullPathname(name string) (string, error) { return "/mem/" + name, nil }
+func (v *memVFS) TmpDir() string                           { return "/mem" }
+func (v *memVFS) Sleep(d time.Duration)                    {}
+func (v *memVFS) CurrentTime() time.Time                   { return time.Now() }
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	return copy(f.data[off:], p), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if size < int64(len(f.data)) {
+		f.data = f.data[:size]
+	}
+	return nil
+}
+
+func (f *memFile) Close() error             { return nil }
+func (f *memFile) Sync() error              { return nil }
+func (f *memFile) FileSize() (int64, error) { return int64(len(f.data)), nil }
+
+func (f *memFile) Lock(level LockLevel) error {
+	f.locks = append(f.locks, fmt.Sprintf("lock %d", level))
+	return nil
+}
+
+func (f *memFile) Unlock(level LockLevel) error {
+	f.locks = append(f.locks, fmt.Sprintf("unlock %d", level))
+	return nil
+}
+
+func TestVFS(t *testing.T) {
+	Describe(t, "Normal", func() {
+		Context("Library", func() {
+			It("Library.SetVFS", func() {
+				vfs := &memVFS{files: map[string]*memFile{}}
+				Expect(Info().Shutdown()).To(NotExist)
+				Expect(Info().SetVFS(vfs)).To(NotExist)
+				defer func() {
+					Expect(Info().Shutdown()).To(NotExist)
+					Expect(Info().SetVFS(nil)).To(NotExist)
+				}()
+
+				db, err := NewDatabase("app.db")
+				Expect(err).To(NotExist)
+				Expect(errors.Is(Info().SetVFS(nil), ErrLocked)).To(Equal, true)
+				other := &memVFS{files: map[string]*memFile{}}
+				Expect(errors.Is(Info().SetVFS(other), ErrLocked)).To(Equal, true)
+				Expect(db.Store([]byte("key"), []byte("value"))).To(NotExist)
+				value, err := db.Fetch([]byte("key"))
+				Expect(err).To(NotExist)
+				Expect(value).To(Equal, []byte("value"))
+				Expect(db.Close()).To(NotExist)
+				Expect(vfs.opened[0]).To(Equal, "/mem/app.db")
+				Expect(other.opened).To(NotExist)
+				Expect(vfs.files["/mem/app.db"].data).To(Exist)
+				_, err = os.Stat("app.db")
+				Expect(os.IsNotExist(err)).To(Equal, true)
+
+				db, err = NewDatabase("app.db")
+				Expect(err).To(NotExist)
+				v, err := db.Fetch([]byte("key"))
+				Expect(err).To(NotExist)
+				Expect(string(v)).To(Equal, "value")
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+
+		Context("Database", func() {
+			It("WithVFS", func() {
+				vfs := &memVFS{files: map[string]*memFile{}}
+				db, err := OpenDatabase("app.db", WithVFS(vfs))
+				Expect(err).To(NotExist)
+				other, err := NewDatabase(filepath.Join(t.TempDir(), "other.db"))
+				Expect(err).To(NotExist)
+				Expect(db.Store([]byte("key"), []byte("value"))).To(NotExist)
+				Expect(other.Store([]byte("key"), []byte("other"))).To(NotExist)
+				Expect(db.Close()).To(NotExist)
+				Expect(other.Close()).To(NotExist)
+				Expect(vfs.opened[0]).To(Equal, "/mem/app.db")
+				Expect(len(vfs.opened)).To(Equal, 2)
+				_, err = os.Stat("app.db")
+				Expect(os.IsNotExist(err)).To(Equal, true)
+
+				db, err = OpenDatabase("app.db", WithVFS(vfs), ReadOnly())
+				Expect(err).To(NotExist)
+				v, err := db.Fetch([]byte("key"))
+				Expect(err).To(NotExist)
+				Expect(string(v)).To(Equal, "value")
+				Expect(db.Close()).To(NotExist)
+
+				_, err = OpenDatabase("app.db", WithVFS(vfs), MMap())
+				Expect(errors.Is(err, ErrInvalidMode)).To(Equal, true)
+				_, err = OpenDatabase("\x01app.db", WithVFS(vfs))
+				Expect(err).To(Equal, ErrInvalidConfig)
+			})
+
+			It("OS.Lock", func() {
+				name := filepath.Join(t.TempDir(), "lock.db")
+				db, err := NewDatabase(name)
+				Expect(err).To(NotExist)
+				other, err := NewDatabase(name)
+				Expect(err).To(NotExist)
+
+				Expect(db.Begin()).To(NotExist)
+				Expect(db.Store([]byte("key"), []byte("value"))).To(NotExist)
+				Expect(errors.Is(other.Store([]byte("key"), []byte("other")), ErrBusy)).To(Equal, true)
+				Expect(other.Close()).To(NotExist)
+				Expect(db.Commit()).To(NotExist)
+
+				other, err = NewDatabase(name)
+				Expect(err).To(NotExist)
+				v, err := other.Fetch([]byte("key"))
+				Expect(err).To(NotExist)
+				Expect(string(v)).To(Equal, "value")
+				Expect(db.Close()).To(NotExist)
+				Expect(other.Store([]byte("key"), []byte("other"))).To(NotExist)
+				Expect(other.Close()).To(NotExist)
+			})
+		})
+	})
+}
+
+func TestEncryption(t *testing.T) {
+	Describe(t, "Normal", func() {
+		Context("Encryption", func() {
+			It("WithEncryption", func() {
+				name := filepath.Join(t.TempDir(), "enc.db")
+				key1 := bytes.Repeat([]byte{1}, 32)
+				key2 := bytes.Repeat([]byte{2}, 32)
+				keys := func(key []byte) KeyProvider {
+					return func() ([]byte, error) { return key, nil }
+				}
+
+				db, err := OpenDatabase(name, WithEncryption(keys(key1)))
+				Expect(err).To(NotExist)
+				for i := 0; i < 100; i++ {
+					Expect(db.Store([]byte(fmt.Sprintf("key%d", i)), []byte("plaintext value"))).To(NotExist)
+				}
+				Expect(db.Close()).To(NotExist)
+				raw, err := ioutil.ReadFile(name)
+				Expect(err).To(NotExist)
+				Expect(bytes.Contains(raw, []byte("plaintext value"))).To(Equal, false)
+
+				_, err = OpenDatabase(name, WithEncryption(keys(key2)))
+				Expect(errors.Is(err, ErrWrongKey)).To(Equal, true)
+				_, err = OpenDatabase(name, WithEncryption(keys(key1[:16])))
+				Expect(err).To(Equal, ErrInvalidConfig)
+				_, err = OpenDatabase("", WithEncryption(keys(key1)))
+				Expect(err).To(Equal, ErrInvalidConfig)
+
+				db, err = OpenDatabase(name, WithEncryption(keys(key1)))
+				Expect(err).To(NotExist)
+				Expect(db.Store([]byte("key100"), []byte("new value"))).To(NotExist)
+				Expect(db.Rekey(key2)).To(Equal, ErrTxOpen)
+				Expect(db.Commit()).To(NotExist)
+				Expect(db.Update(func(tx *Tx) error { return db.Rekey(key2) })).To(Equal, ErrTxOpen)
+				Expect(db.Rekey(key2)).To(NotExist)
+				v, err := db.Fetch([]byte("key7"))
+				Expect(err).To(NotExist)
+				Expect(string(v)).To(Equal, "plaintext value")
+				Expect(db.Close()).To(NotExist)
+
+				_, err = OpenDatabase(name, WithEncryption(keys(key1)))
+				Expect(errors.Is(err, ErrWrongKey)).To(Equal, true)
+				db, err = OpenDatabase(name, ReadOnly(), WithEncryption(keys(key2)))
+				Expect(err).To(NotExist)
+				v, err = db.Fetch([]byte("key100"))
+				Expect(err).To(NotExist)
+				Expect(string(v)).To(Equal, "new value")
+				Expect(db.Close()).To(NotExist)
+			})
+
+			It("Rekey.Lock", func() {
+				vfs := &memVFS{files: map[string]*memFile{}}
+				key := func() ([]byte, error) { return bytes.Repeat([]byte{1}, 32), nil }
+				db, err := OpenDatabase("enc.db", WithVFS(vfs), WithEncryption(key))
+				Expect(err).To(NotExist)
+				Expect(db.Store([]byte("key"), []byte("value"))).To(NotExist)
+				Expect(db.Commit()).To(NotExist)
+
+				f := vfs.files["/mem/enc.db"]
+				f.locks = nil
+				Expect(db.Rekey(bytes.Repeat([]byte{2}, 32))).To(NotExist)
+				// The engine gets its RESERVED lock back before the rollback
+				Expect(f.locks).To(Equal, []string{"lock 2", "lock 4", "unlock 1", "lock 2", "unlock 1"})
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+}
+
+// sortedEngine is a KVEngine keeping its records in a sorted slice.
+type sortedEngine struct {
+	keys, values [][]byte
+}
+
+type sortedCursor struct {
+	e *sortedEngine
+	i int
+}
+
+func (e *sortedEngine) find(key []byte) (int, bool) {
+	i := sort.Search(len(e.keys), func(i int) bool { return bytes.Compare(e.keys[i], key) >= 0 })
+	return i, i < len(e.keys) && bytes.Equal(e.keys[i], key)
+}
+
+func (e *sortedEngine) Init(pageSize int) error { return nil }
+func (e *sortedEngine) Open(pages int64) error  { return nil }
+func (e *sortedEngine) Release()                {}
+func (e *sortedEngine) Cursor() KVCursor        { return &sortedCursor{e: e} }
+
+func (e *sortedEngine) Replace(key, value []byte) error {
+	if string(key) == "fail" {
+		return errors.New("sorted: refused")
+	}
+	i, ok := e.find(key)
+	if !ok {
+		e.keys = append(e.keys[:i], append([][]byte{append([]byte{}, key...)}, e.keys[i:]...)...)
+		e.values = append(e.values[:i], append([][]byte{nil}, e.values[i:]...)...)
+	}
+	e.values[i] = append([]byte{}, value...)
+	return nil
+}
+
+func (e *sortedEngine) Append(key, value []byte) error {
+	i, ok := e.find(key)
+	if !ok {
+		return e.Replace(key, value)
+	}
+	e.values[i] = append(e.values[i], value...)
+	return nil
+}
+
+func (c *sortedCursor) Seek(key []byte, match SeekMatch) error {
+	i, ok := c.e.find(key)
+	switch {
+	case ok:
+	case match == SeekGE && i < len(c.e.keys):
+	case match == SeekLE && i > 0:
+		i--
+	default:
+		c.i = len(c.e.keys)
+		return ErrNotFound
+	}
+	c.i = i
+	return nil
+}
+
+func (c *sortedCursor) First() error           { c.i = 0; return nil }
+func (c *sortedCursor) Last() error            { c.i = len(c.e.keys) - 1; return nil }
+func (c *sortedCursor) Next() error            { c.i++; return nil }
+func (c *sortedCursor) Prev() error            { c.i--; return nil }
+func (c *sortedCursor) Valid() bool            { return c.i >= 0 && c.i < len(c.e.keys) }
+func (c *sortedCursor) Key() ([]byte, error)   { return c.e.keys[c.i], nil }
+func (c *sortedCursor) Value() ([]byte, error) { return c.e.values[c.i], nil }
+func (c *sortedCursor) Reset()                 { c.i = 0 }
+func (c *sortedCursor) Close()                 {}
+
+func (c *sortedCursor) Delete() error {
+	c.e.keys = append(c.e.keys[:c.i], c.e.keys[c.i+1:]...)
+	c.e.values = append(c.e.values[:c.i], c.e.values[c.i+1:]...)
+	return nil
+}
+
+// brokenEngine is a KVEngine which cannot be initialized.
+type brokenEngine struct {
+	sortedEngine
+}
+
+func (*brokenEngine) Init(pageSize int) error { return errors.New("broken: no init") }
+
+func TestKVEngine(t *testing.T) {
+	Describe(t, "Normal", func() {
+		Context("KVEngine", func() {
+			It("Library.RegisterKVEngine", func() {
+				newEngine := func() KVEngine { return &sortedEngine{} }
+				Expect(Info().RegisterKVEngine("sorted", newEngine)).To(NotExist)
+				Expect(errors.Is(Info().RegisterKVEngine("Sorted", newEngine), ErrExists)).To(Equal, true)
+				Expect(errors.Is(Info().RegisterKVEngine("mem", newEngine), ErrExists)).To(Equal, true)
+				Expect(Info().RegisterKVEngine("", newEngine)).To(Equal, error(ErrInvalidConfig))
+
+				// The library stays configurable until it is initialized
+				Expect(Info().Shutdown()).To(NotExist)
+				Expect(Info().RegisterKVEngine("late", newEngine)).To(NotExist)
+				Expect(errors.Is(Info().RegisterKVEngine("LATE", newEngine), ErrExists)).To(Equal, true)
+				Expect(Info().IsInitialized()).To(Equal, false)
+				Expect(Info().Configure(LibraryOptions{})).To(NotExist)
+				Expect(Info().SetVFS(nil)).To(NotExist)
+				db, err := OpenDatabase("", WithConfig(Config{KVEngine: "late"}))
+				Expect(err).To(NotExist)
+				name, err := db.KVEngine()
+				Expect(err).To(NotExist)
+				Expect(name).To(Equal, "late")
+				Expect(db.Close()).To(NotExist)
+			})
+
+			It("Database.SetKVEngine", func() {
+				db, err := OpenDatabase("", WithConfig(Config{KVEngine: "sorted"}))
+				Expect(err).To(NotExist)
+				defer db.Close()
+				name, err := db.KVEngine()
+				Expect(err).To(NotExist)
+				Expect(name).To(Equal, "sorted")
+				Expect(errors.Is(db.SetKVEngine("missing"), ErrNotImplemented)).To(Equal, true)
+
+				for _, k := range []string{"c", "a", "d", "b"} {
+					Expect(db.Store([]byte(k), []byte(strings.ToUpper(k)))).To(NotExist)
+				}
+				Expect(db.Append([]byte("a"), []byte("A"))).To(NotExist)
+				Expect(db.Delete([]byte("d"))).To(NotExist)
+				v, err := db.Fetch([]byte("a"))
+				Expect(err).To(NotExist)
+				Expect(string(v)).To(Equal, "AA")
+				_, err = db.Fetch([]byte("d"))
+				Expect(errors.Is(err, ErrNotFound)).To(Equal, true)
+
+				var keys []string
+				entries, errf := db.All()
+				for k := range entries {
+					keys = append(keys, string(k))
+				}
+				Expect(errf()).To(NotExist)
+				Expect(strings.Join(keys, ",")).To(Equal, "a,b,c")
+
+				cr, err := db.Cursor()
+				Expect(err).To(NotExist)
+				Expect(cr.SeekGE([]byte("bb"))).To(NotExist)
+				k, err := cr.Key()
+				Expect(err).To(NotExist)
+				Expect(string(k)).To(Equal, "c")
+				Expect(cr.Close()).To(NotExist)
+
+				err = db.Store([]byte("fail"), []byte("x"))
+				Expect(errors.Is(err, ErrIO)).To(Equal, true)
+				Expect(strings.Contains(err.Error(), "sorted: refused")).To(Equal, true)
+			})
+
+			It("Database.SetKVEngine.File", func() {
+				name := filepath.Join(t.TempDir(), "sorted.db")
+				db, err := OpenDatabase(name, WithConfig(Config{KVEngine: "sorted"}))
+				Expect(err).To(NotExist)
+				Expect(db.Store([]byte("key"), []byte("value"))).To(NotExist)
+				Expect(db.Close()).To(NotExist)
+
+				db, err = OpenDatabase(name)
+				Expect(err).To(NotExist)
+				defer db.Close()
+				// The engine is loaded from the header on first access
+				_, err = db.Fetch([]byte("key"))
+				Expect(errors.Is(err, ErrNotFound)).To(Equal, true)
+				name, err = db.KVEngine()
+				Expect(err).To(NotExist)
+				Expect(name).To(Equal, "sorted")
+				Expect(errors.Is(db.SetKVEngine("hash"), ErrLocked)).To(Equal, true)
+			})
+
+			It("Database.SetKVEngine.Init", func() {
+				broken := func() KVEngine { return &brokenEngine{} }
+				Expect(Info().RegisterKVEngine("broken", broken)).To(NotExist)
+				db, err := OpenDatabase("")
+				Expect(err).To(NotExist)
+				defer db.Close()
+
+				// The current engine is kept when the new one cannot be initialized
+				err = db.SetKVEngine("broken")
+				Expect(errors.Is(err, ErrIO)).To(Equal, true)
+				Expect(strings.Contains(err.Error(), "broken: no init")).To(Equal, true)
+				name, err := db.KVEngine()
+				Expect(err).To(NotExist)
+				Expect(name).To(Equal, "mem")
+				Expect(db.Store([]byte("key"), []byte("value"))).To(NotExist)
+				v, err := db.Fetch([]byte("key"))
+				Expect(err).To(NotExist)
+				Expect(string(v)).To(Equal, "value")
+			})
+
+			It("Database.SetKVEngine.Locked", func() {
+				db, err := OpenDatabase("", WithConfig(Config{KVEngine: "sorted"}))
+				Expect(err).To(NotExist)
+				defer db.Close()
+
+				// An open cursor would be left on the released engine
+				cr, err := db.Cursor()
+				Expect(err).To(NotExist)
+				Expect(errors.Is(db.SetKVEngine("mem"), ErrLocked)).To(Equal, true)
+				Expect(cr.Close()).To(NotExist)
+
+				// The records would be lost
+				Expect(db.Store([]byte("key"), []byte("value"))).To(NotExist)
+				Expect(errors.Is(db.SetKVEngine("mem"), ErrLocked)).To(Equal, true)
+				name, err := db.KVEngine()
+				Expect(err).To(NotExist)
+				Expect(name).To(Equal, "sorted")
+				v, err := db.Fetch([]byte("key"))
+				Expect(err).To(NotExist)
+				Expect(string(v)).To(Equal, "value")
+
+				Expect(db.Delete([]byte("key"))).To(NotExist)
+				Expect(db.SetKVEngine("mem")).To(NotExist)
+			})
+
+			It("NewOrderedKVEngine", func() {
+				Expect(Info().RegisterKVEngine("series", NewOrderedKVEngine(Int64BigEndianComparator))).To(NotExist)
+				db, err := OpenDatabase("", WithConfig(Config{KVEngine: "series"}))
+				Expect(err).To(NotExist)
+				defer db.Close()
+
+				key := func(n int64) []byte {
+					b := make([]byte, 8)
+					binary.BigEndian.PutUint64(b, uint64(n))
+					return b
+				}
+				for _, n := range []int64{30, -10, 20, 0, -20} {
+					Expect(db.Store(key(n), []byte(fmt.Sprint(n)))).To(NotExist)
+				}
+
+				cr, err := db.Cursor()
+				Expect(err).To(NotExist)
+				defer cr.Close()
+				value := func() string {
+					v, err := cr.Value()
+					Expect(err).To(NotExist)
+					return string(v)
+				}
+
+				var all []string
+				for cr.First(); cr.IsValid(); cr.Next() {
+					all = append(all, value())
+				}
+				Expect(strings.Join(all, ",")).To(Equal, "-20,-10,0,20,30")
+
+				Expect(cr.SeekGE(key(-15))).To(NotExist)
+				Expect(value()).To(Equal, "-10")
+				Expect(cr.SeekLE(key(10))).To(NotExist)
+				Expect(value()).To(Equal, "0")
+				Expect(errors.Is(cr.SeekGE(key(31)), ErrNotFound)).To(Equal, true)
+				Expect(errors.Is(cr.SeekLE(key(-21)), ErrNotFound)).To(Equal, true)
+
+				Expect(Info().RegisterKVEngine("reverse", NewOrderedKVEngine(ReverseComparator))).To(NotExist)
+				rev, err := OpenDatabase("", WithConfig(Config{KVEngine: "reverse"}))
+				Expect(err).To(NotExist)
+				defer rev.Close()
+				for _, k := range []string{"b", "d", "a", "c"} {
+					Expect(rev.Store([]byte(k), nil)).To(NotExist)
+				}
+				var keys []string
+				entries, errf := rev.All()
+				for k := range entries {
+					keys = append(keys, string(k))
+				}
+				Expect(errf()).To(NotExist)
+				Expect(strings.Join(keys, ",")).To(Equal, "d,c,b,a")
+			})
+
+			It("JX9", func() {
+				db, err := OpenDatabase("", WithConfig(Config{KVEngine: "sorted"}))
+				Expect(err).To(NotExist)
+				defer db.Close()
+
+				vm := NewVM()
+				_, err = db.Compile(`db_create('users'); db_store('users', [{name: 'a'}, {name: 'b'}]); $n = db_total_records('users');`, vm)
+				Expect(err).To(NotExist)
+				Expect(vm.Run()).To(NotExist)
+				n, err := vm.Extract("n")
+				Expect(err).To(NotExist)
+				Expect(n.Int64()).To(Equal, int64(2))
+				Expect(vm.Close()).To(NotExist)
+
+				// The records are read back from the engine by another VM
+				type user struct {
+					ID   int64  `json:"__id"`
+					Name string `json:"name"`
+					Age  int    `json:"age"`
+				}
+				vm = NewVM()
+				_, err = db.Compile(`
+					db_store('users', {name: 'c', age: 3});
+					db_update_record('users', 0, {name: 'z', age: 9});
+					db_drop_record('users', 1);
+					$all = db_fetch_all('users');
+					$one = db_fetch_by_id('users', 2);
+				`, vm)
+				Expect(err).To(NotExist)
+				Expect(vm.Run()).To(NotExist)
+				var all []user
+				Expect(vm.ExtractInto("all", &all)).To(NotExist)
+				Expect(all).To(Equal, []user{{ID: 0, Name: "z", Age: 9}, {ID: 2, Name: "c", Age: 3}})
+				var one user
+				Expect(vm.ExtractInto("one", &one)).To(NotExist)
+				Expect(one).To(Equal, user{ID: 2, Name: "c", Age: 3})
+				Expect(vm.Close()).To(NotExist)
+			})
+
+			It("Library.Shutdown", func() {
+				Expect(Info().Shutdown()).To(NotExist)
+				db, err := OpenDatabase("", WithConfig(Config{KVEngine: "sorted"}))
+				Expect(err).To(NotExist)
+				Expect(db.Close()).To(NotExist)
+			})
+		})
+	})
+}
@@ -0,0 +1,437 @@
+// Package unqlitetest provides a fault-injecting in-memory file system for testing
+// code using unqlitego against I/O errors, full disks and crashes.
+//
+// A VFS is used through the WithVFS option:
+//
+//	vfs := unqlitetest.NewVFS()
+//	db, err := unqlitego.OpenDatabase("test.db", unqlitego.WithVFS(vfs))
+//
+// The VFS keeps apart the content of a file as seen by reads and the content
+// which reached stable storage through a sync. Crash drops everything not synced,
+// like a power loss would.
+package unqlitetest
+
+import (
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	ugo "github.com/GJRTimmer/unqlitego"
+)
+
+// VFS is an in-memory unqlitego.VFS with fault injection, safe for concurrent use.
+// Creating and deleting files is durable at once, file content is only durable once synced.
+type VFS struct {
+	mu sync.Mutex
+
+	// Files by full path
+	files map[string]*file
+
+	// Generation of the open files, a crash invalidates all files opened before it
+	gen int
+
+	// Writes and syncs performed
+	writes int
+	syncs  int
+
+	// Pending faults, the write or sync count they trigger at
+	failWrite, tearWrite, failSync int
+	failWriteErr, failSyncErr      error
+
+	// Total size of all files, zero for unlimited
+	capacity int64
+}
+
+// file is the content and lock state of a file of the VFS.
+type file struct {
+	data   []byte
+	synced []byte
+
+	// Lock state, the handles holding each level
+	shared    int
+	reserved  *handle
+	pending   *handle
+	exclusive *handle
+}
+
+// handle is an open file of the VFS.
+type handle struct {
+	vfs  *VFS
+	f    *file
+	gen  int
+	lock ugo.LockLevel
+}
+
+// NewVFS creates an empty VFS.
+func NewVFS() *VFS {
+	return &VFS{files: make(map[string]*file)}
+}
+
+// FailWrite makes the nth write from now fail with err, counting from 1.
+// A nil err fails the write with unqlitego.ErrIO.
+func (v *VFS) FailWrite(n int, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err == nil {
+		err = ugo.ErrIO
+	}
+	v.failWrite, v.failWriteErr = v.writes+n, err
+}
+
+// FailSync makes the nth sync from now fail with err, counting from 1.
+// A nil err fails the sync with unqlitego.ErrIO.
+func (v *VFS) FailSync(n int, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err == nil {
+		err = ugo.ErrIO
+	}
+	v.failSync, v.failSyncErr = v.syncs+n, err
+}
+
+// TearWrite makes the nth write from now a torn write, counting from 1. Only the
+// first half of the write reaches stable storage before the VFS crashes.
+func (v *VFS) TearWrite(n int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.tearWrite = v.writes + n
+}
+
+// SetCapacity limits the total size of all files to n bytes, zero removes the limit.
+// Writes growing the files beyond it fail with unqlitego.ErrFull, like on a full disk.
+func (v *VFS) SetCapacity(n int64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.capacity = n
+}
+
+// Writes returns the number of writes performed, use it to sweep FailWrite and
+// TearWrite over every write of an operation.
+func (v *VFS) Writes() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.writes
+}
+
+// Crash emulates a power loss. The content of every file is reset to what was
+// last synced, locks are released and all open files fail with unqlitego.ErrIO.
+// Pending faults are cleared. Databases opened afterwards see the recovered state,
+// databases opened before should be closed, ignoring the error.
+func (v *VFS) Crash() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.crash()
+}
+
+func (v *VFS) crash() {
+	for _, f := range v.files {
+		f.data = append([]byte{}, f.synced...)
+		f.shared, f.reserved, f.pending, f.exclusive = 0, nil, nil, nil
+	}
+	v.gen++
+	v.failWrite, v.tearWrite, v.failSync = 0, 0, 0
+}
+
+// ReadFile returns the current content of the file name.
+func (v *VFS) ReadFile(name string) ([]byte, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	f, ok := v.files[fullPath(name)]
+	if !ok {
+		return nil, false
+	}
+
+	return append([]byte{}, f.data...), true
+}
+
+// size returns the total size of all files.
+func (v *VFS) size() int64 {
+	var n int64
+	for _, f := range v.files {
+		n += int64(len(f.data))
+	}
+
+	return n
+}
+
+// fullPath returns the absolute form of name.
+func fullPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// Open implements unqlitego.VFS.
+func (v *VFS) Open(name string, mode ugo.Mode) (ugo.File, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	name = fullPath(name)
+	f, ok := v.files[name]
+	if !ok {
+		if !mode.Has(ugo.ModeCreate) {
+			return nil, ugo.ErrIO
+		}
+		f = &file{}
+		v.files[name] = f
+	}
+
+	return &handle{vfs: v, f: f, gen: v.gen}, nil
+}
+
+// Delete implements unqlitego.VFS.
+func (v *VFS) Delete(name string, syncDir bool) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	name = fullPath(name)
+	if _, ok := v.files[name]; !ok {
+		return ugo.ErrIO
+	}
+	delete(v.files, name)
+
+	return nil
+}
+
+// Access implements unqlitego.VFS.
+func (v *VFS) Access(name string, flag ugo.AccessFlag) (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	f, ok := v.files[fullPath(name)]
+	if !ok {
+		return false, nil
+	}
+	if flag == ugo.AccessExists {
+		return len(f.data) > 0, nil
+	}
+
+	return true, nil
+}
+
+// FullPathname implements unqlitego.VFS.
+func (v *VFS) FullPathname(name string) (string, error) {
+	return fullPath(name), nil
+}
+
+// TmpDir implements unqlitego.VFS.
+func (v *VFS) TmpDir() string {
+	return "/tmp"
+}
+
+// Sleep implements unqlitego.VFS.
+func (v *VFS) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// CurrentTime implements unqlitego.VFS.
+func (v *VFS) CurrentTime() time.Time {
+	return time.Now()
+}
+
+// valid reports whether the handle was opened since the last crash, the VFS is locked.
+func (h *handle) valid() bool {
+	return h.gen == h.vfs.gen
+}
+
+// ReadAt implements unqlitego.File.
+func (h *handle) ReadAt(p []byte, off int64) (int, error) {
+	h.vfs.mu.Lock()
+	defer h.vfs.mu.Unlock()
+
+	if !h.valid() {
+		return 0, ugo.ErrIO
+	}
+	if off >= int64(len(h.f.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, h.f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// WriteAt implements unqlitego.File.
+func (h *handle) WriteAt(p []byte, off int64) (int, error) {
+	v := h.vfs
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !h.valid() {
+		return 0, ugo.ErrIO
+	}
+
+	v.writes++
+	switch v.writes {
+	case v.failWrite:
+		return 0, v.failWriteErr
+	case v.tearWrite:
+		half := p[:len(p)/2]
+		h.f.synced = write(h.f.synced, half, off)
+		v.crash()
+		return 0, ugo.ErrIO
+	}
+
+	if end := off + int64(len(p)); v.capacity > 0 && end > int64(len(h.f.data)) {
+		if v.size()+end-int64(len(h.f.data)) > v.capacity {
+			return 0, ugo.ErrFull
+		}
+	}
+	h.f.data = write(h.f.data, p, off)
+
+	return len(p), nil
+}
+
+// write writes p at off into b, growing it as needed.
+func write(b, p []byte, off int64) []byte {
+	if end := off + int64(len(p)); end > int64(len(b)) {
+		b = append(b, make([]byte, end-int64(len(b)))...)
+	}
+	copy(b[off:], p)
+
+	return b
+}
+
+// Truncate implements unqlitego.File.
+func (h *handle) Truncate(size int64) error {
+	h.vfs.mu.Lock()
+	defer h.vfs.mu.Unlock()
+
+	if !h.valid() {
+		return ugo.ErrIO
+	}
+	if size < int64(len(h.f.data)) {
+		h.f.data = h.f.data[:size]
+	} else {
+		h.f.data = write(h.f.data, nil, size)
+	}
+
+	return nil
+}
+
+// Sync implements unqlitego.File.
+func (h *handle) Sync() error {
+	v := h.vfs
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !h.valid() {
+		return ugo.ErrIO
+	}
+
+	v.syncs++
+	if v.syncs == v.failSync {
+		return v.failSyncErr
+	}
+	h.f.synced = append([]byte{}, h.f.data...)
+
+	return nil
+}
+
+// FileSize implements unqlitego.File.
+func (h *handle) FileSize() (int64, error) {
+	h.vfs.mu.Lock()
+	defer h.vfs.mu.Unlock()
+
+	if !h.valid() {
+		return 0, ugo.ErrIO
+	}
+
+	return int64(len(h.f.data)), nil
+}
+
+// Lock implements unqlitego.File with the locking protocol of the engine, a lock
+// held by another handle returns unqlitego.ErrBusy.
+func (h *handle) Lock(level ugo.LockLevel) error {
+	h.vfs.mu.Lock()
+	defer h.vfs.mu.Unlock()
+
+	if !h.valid() {
+		return ugo.ErrIO
+	}
+
+	f := h.f
+	if level <= h.lock {
+		return nil
+	}
+	if (f.pending != nil && f.pending != h) || (f.exclusive != nil && f.exclusive != h) {
+		return ugo.ErrBusy
+	}
+
+	switch {
+	case level == ugo.LockShared:
+		f.shared++
+	case level == ugo.LockReserved:
+		if f.reserved != nil {
+			return ugo.ErrBusy
+		}
+		f.reserved = h
+	default:
+		// The other readers must be gone for an exclusive lock,
+		// the pending lock keeps new readers out meanwhile.
+		f.pending = h
+		if f.shared > 1 {
+			h.lock = ugo.LockPending
+			return ugo.ErrBusy
+		}
+		f.exclusive = h
+		level = ugo.LockExclusive
+	}
+	h.lock = level
+
+	return nil
+}
+
+// Unlock implements unqlitego.File.
+func (h *handle) Unlock(level ugo.LockLevel) error {
+	h.vfs.mu.Lock()
+	defer h.vfs.mu.Unlock()
+
+	if !h.valid() || level >= h.lock {
+		return nil
+	}
+
+	f := h.f
+	if f.reserved == h {
+		f.reserved = nil
+	}
+	if f.pending == h {
+		f.pending = nil
+	}
+	if f.exclusive == h {
+		f.exclusive = nil
+	}
+	if level == ugo.LockNone {
+		f.shared--
+	}
+	h.lock = level
+
+	return nil
+}
+
+// CheckReservedLock implements unqlitego.ReservedLockChecker.
+func (h *handle) CheckReservedLock() (bool, error) {
+	h.vfs.mu.Lock()
+	defer h.vfs.mu.Unlock()
+
+	if !h.valid() {
+		return false, ugo.ErrIO
+	}
+
+	f := h.f
+	return f.reserved != nil || f.pending != nil || f.exclusive != nil, nil
+}
+
+// Close implements unqlitego.File, the locks of the handle are released.
+func (h *handle) Close() error {
+	return h.Unlock(ugo.LockNone)
+}
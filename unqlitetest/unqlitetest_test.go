@@ -0,0 +1,259 @@
+package unqlitetest_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	ugo "github.com/GJRTimmer/unqlitego"
+	. "github.com/GJRTimmer/unqlitego/unqlitetest"
+	. "github.com/r7kamura/gospel"
+)
+
+const records = 200
+
+// seed creates the database test.db on vfs holding the records with value.
+func seed(t *testing.T, vfs *VFS, value string) {
+	t.Helper()
+
+	db, err := ugo.OpenDatabase("test.db", ugo.WithVFS(vfs))
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	if err = store(db, value); err == nil {
+		err = db.Close()
+	}
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+}
+
+// store writes value to all records within a transaction, without committing it.
+func store(db *ugo.Database, value string) error {
+	if err := db.Begin(); err != nil {
+		return err
+	}
+	for i := 0; i < records; i++ {
+		if err := db.Store([]byte(fmt.Sprintf("key%d", i)), bytes.Repeat([]byte(value), 32)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// state returns the value all records hold, or "torn" when they differ.
+func state(vfs *VFS, opts ...ugo.Option) string {
+	db, err := ugo.OpenDatabase("test.db", append([]ugo.Option{ugo.WithVFS(vfs)}, opts...)...)
+	if err != nil {
+		return err.Error()
+	}
+	defer db.Close()
+
+	var value string
+	for i := 0; i < records; i++ {
+		v, err := db.Fetch([]byte(fmt.Sprintf("key%d", i)))
+		if err != nil {
+			return err.Error()
+		}
+		if i == 0 {
+			value = string(v)
+		} else if string(v) != value {
+			return "torn"
+		}
+	}
+
+	return value[:len(value)/32]
+}
+
+func TestCrash(t *testing.T) {
+	Describe(t, "Crash", func() {
+		Context("Commit", func() {
+			It("keeps committed transactions", func() {
+				vfs := NewVFS()
+				seed(t, vfs, "a")
+				db, err := ugo.OpenDatabase("test.db", ugo.WithVFS(vfs))
+				Expect(err).To(NotExist)
+				Expect(store(db, "b")).To(NotExist)
+				Expect(db.Commit()).To(NotExist)
+				vfs.Crash()
+				db.Close()
+				Expect(state(vfs)).To(Equal, "b")
+			})
+
+			It("drops uncommitted transactions", func() {
+				vfs := NewVFS()
+				seed(t, vfs, "a")
+				db, err := ugo.OpenDatabase("test.db", ugo.WithVFS(vfs))
+				Expect(err).To(NotExist)
+				Expect(store(db, "b")).To(NotExist)
+				vfs.Crash()
+				db.Close()
+				Expect(state(vfs)).To(Equal, "a")
+			})
+		})
+
+		Context("Rollback", func() {
+			It("restores the records", func() {
+				vfs := NewVFS()
+				seed(t, vfs, "a")
+				db, err := ugo.OpenDatabase("test.db", ugo.WithVFS(vfs))
+				Expect(err).To(NotExist)
+				Expect(store(db, "b")).To(NotExist)
+				Expect(db.Rollback()).To(NotExist)
+				Expect(db.Close()).To(NotExist)
+				Expect(state(vfs)).To(Equal, "a")
+			})
+		})
+	})
+}
+
+// sweep runs a transaction on a fresh database for every write it performs,
+// fault injects the fault at the nth write. As soon as the transaction fails
+// the VFS crashes, or the transaction is rolled back when crash is false.
+// It returns the states afterwards, and the errors returned by the transactions.
+func sweep(t *testing.T, fault func(vfs *VFS, n int), crash bool) (states []string, errs []error) {
+	t.Helper()
+
+	for n := 1; ; n++ {
+		vfs := NewVFS()
+		seed(t, vfs, "a")
+
+		db, err := ugo.OpenDatabase("test.db", ugo.WithVFS(vfs))
+		if err != nil {
+			t.Fatalf("sweep: %v", err)
+		}
+		before := vfs.Writes()
+		fault(vfs, n)
+		err = store(db, "b")
+		if err == nil {
+			err = db.Commit()
+		}
+		if err == nil && vfs.Writes()-before < n {
+			// The fault was never hit
+			db.Close()
+			return
+		}
+		if crash {
+			vfs.Crash()
+		} else {
+			db.Rollback()
+		}
+		db.Close()
+
+		states = append(states, state(vfs))
+		errs = append(errs, err)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	Describe(t, "Faults", func() {
+		Context("FailWrite", func() {
+			It("recovers the journal after a crash", func() {
+				states, errs := sweep(t, func(vfs *VFS, n int) { vfs.FailWrite(n, nil) }, true)
+				Expect(len(states) > 1).To(Equal, true)
+				for i, s := range states {
+					Expect(errors.Is(errs[i], ugo.ErrIO)).To(Equal, true)
+					Expect(s).To(Equal, "a")
+				}
+			})
+
+			It("restores the records on Rollback", func() {
+				states, errs := sweep(t, func(vfs *VFS, n int) { vfs.FailWrite(n, nil) }, false)
+				Expect(len(states) > 1).To(Equal, true)
+				for i, s := range states {
+					Expect(errors.Is(errs[i], ugo.ErrIO)).To(Equal, true)
+					Expect(s).To(Equal, "a")
+				}
+			})
+		})
+
+		Context("TearWrite", func() {
+			It("recovers from a torn write", func() {
+				states, _ := sweep(t, func(vfs *VFS, n int) { vfs.TearWrite(n) }, true)
+				Expect(len(states) > 1).To(Equal, true)
+				for _, s := range states {
+					Expect(s).To(Equal, "a")
+				}
+			})
+		})
+
+		Context("FailSync", func() {
+			It("keeps the records consistent", func() {
+				vfs := NewVFS()
+				seed(t, vfs, "a")
+				db, err := ugo.OpenDatabase("test.db", ugo.WithVFS(vfs))
+				Expect(err).To(NotExist)
+				vfs.FailSync(1, nil)
+				Expect(store(db, "b")).To(NotExist)
+				Expect(errors.Is(db.Commit(), ugo.ErrIO)).To(Equal, true)
+				db.Close()
+				vfs.Crash()
+				Expect(state(vfs)).To(Equal, "a")
+			})
+		})
+
+		Context("Rekey", func() {
+			It("keeps the current key when a write fails", func() {
+				key := func(b byte) ugo.Option {
+					return ugo.WithEncryption(func() ([]byte, error) { return bytes.Repeat([]byte{b}, 32), nil })
+				}
+
+				for n := 1; ; n++ {
+					vfs := NewVFS()
+					db, err := ugo.OpenDatabase("test.db", ugo.WithVFS(vfs), key(1))
+					Expect(err).To(NotExist)
+					Expect(store(db, "a")).To(NotExist)
+					Expect(db.Commit()).To(NotExist)
+
+					before := vfs.Writes()
+					vfs.FailWrite(n, nil)
+					err = db.Rekey(bytes.Repeat([]byte{2}, 32))
+					if err == nil {
+						Expect(vfs.Writes()-before < n).To(Equal, true)
+						Expect(db.Close()).To(NotExist)
+						Expect(n > 2).To(Equal, true)
+						break
+					}
+					Expect(errors.Is(err, ugo.ErrIO)).To(Equal, true)
+					v, err := db.Fetch([]byte("key0"))
+					Expect(err).To(NotExist)
+					Expect(string(v[:1])).To(Equal, "a")
+					Expect(state(vfs, key(1))).To(Equal, "a")
+
+					// The file is back on the current key, a new rekey starts from it
+					Expect(db.Rekey(bytes.Repeat([]byte{3}, 32))).To(NotExist)
+					Expect(db.Close()).To(NotExist)
+					Expect(state(vfs, key(3))).To(Equal, "a")
+				}
+			})
+		})
+
+		Context("SetCapacity", func() {
+			It("fails writes on a full disk", func() {
+				vfs := NewVFS()
+				seed(t, vfs, "a")
+				db, err := ugo.OpenDatabase("test.db", ugo.WithVFS(vfs))
+				Expect(err).To(NotExist)
+				data, _ := vfs.ReadFile("test.db")
+				vfs.SetCapacity(int64(len(data)) + 1024)
+				err = store(db, "bb")
+				if err == nil {
+					err = db.Commit()
+				}
+				Expect(errors.Is(err, ugo.ErrFull)).To(Equal, true)
+				db.Rollback()
+				Expect(db.Close()).To(NotExist)
+				Expect(state(vfs)).To(Equal, "a")
+
+				vfs.SetCapacity(0)
+				db, err = ugo.OpenDatabase("test.db", ugo.WithVFS(vfs))
+				Expect(err).To(NotExist)
+				Expect(store(db, "bb")).To(NotExist)
+				Expect(db.Close()).To(NotExist)
+				Expect(state(vfs)).To(Equal, "bb")
+			})
+		})
+	})
+}
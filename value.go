@@ -0,0 +1,318 @@
+package unqlitego
+
+// #include <unqlite.h>
+// #include <wrappers.h>
+// #include <stdlib.h>
+import "C"
+
+import (
+	"encoding/json"
+	"math"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// Value represents a JX9 value owned by the Virtual Machine.
+// A Value is only valid while the VM call it was obtained from is in progress,
+// for foreign function arguments this is until the function returns and for
+// extracted variables until the VM is reset or released.
+// The zero Value reads as null.
+type Value struct {
+	v *C.unqlite_value
+}
+
+// IsNull returns a boolean indicating if the value is null.
+func (v Value) IsNull() bool {
+	return v.v == nil || C.unqlite_value_is_null(v.v) != 0
+}
+
+// IsInt returns a boolean indicating if the value is an integer.
+func (v Value) IsInt() bool {
+	return v.v != nil && C.unqlite_value_is_int(v.v) != 0
+}
+
+// IsFloat returns a boolean indicating if the value is a float.
+func (v Value) IsFloat() bool {
+	return v.v != nil && C.unqlite_value_is_float(v.v) != 0
+}
+
+// IsBool returns a boolean indicating if the value is a boolean.
+func (v Value) IsBool() bool {
+	return v.v != nil && C.unqlite_value_is_bool(v.v) != 0
+}
+
+// IsString returns a boolean indicating if the value is a string.
+func (v Value) IsString() bool {
+	return v.v != nil && C.unqlite_value_is_string(v.v) != 0
+}
+
+// IsNumeric returns a boolean indicating if the value is numeric, including numeric strings.
+func (v Value) IsNumeric() bool {
+	return v.v != nil && C.unqlite_value_is_numeric(v.v) != 0
+}
+
+// IsCallable returns a boolean indicating if the value is callable.
+func (v Value) IsCallable() bool {
+	return v.v != nil && C.unqlite_value_is_callable(v.v) != 0
+}
+
+// IsScalar returns a boolean indicating if the value is a scalar.
+func (v Value) IsScalar() bool {
+	return v.v != nil && C.unqlite_value_is_scalar(v.v) != 0
+}
+
+// IsArray returns a boolean indicating if the value is a JSON array, JSON objects are arrays too.
+func (v Value) IsArray() bool {
+	return v.v != nil && C.unqlite_value_is_json_array(v.v) != 0
+}
+
+// IsObject returns a boolean indicating if the value is a JSON object.
+func (v Value) IsObject() bool {
+	return v.v != nil && C.unqlite_value_is_json_object(v.v) != 0
+}
+
+// IsResource returns a boolean indicating if the value is a resource.
+func (v Value) IsResource() bool {
+	return v.v != nil && C.unqlite_value_is_resource(v.v) != 0
+}
+
+// IsEmpty returns a boolean indicating if the value is empty (null, false, 0, "" or an empty array).
+func (v Value) IsEmpty() bool {
+	return v.v == nil || C.unqlite_value_is_empty(v.v) != 0
+}
+
+// Int returns the value cast to int.
+func (v Value) Int() int {
+	if v.v == nil {
+		return 0
+	}
+
+	return int(C.unqlite_value_to_int(v.v))
+}
+
+// Int64 returns the value cast to int64.
+func (v Value) Int64() int64 {
+	if v.v == nil {
+		return 0
+	}
+
+	return int64(C.unqlite_value_to_int64(v.v))
+}
+
+// Bool returns the value cast to bool.
+func (v Value) Bool() bool {
+	if v.v == nil {
+		return false
+	}
+
+	return C.unqlite_value_to_bool(v.v) != 0
+}
+
+// Float64 returns the value cast to float64.
+func (v Value) Float64() float64 {
+	if v.v == nil {
+		return 0
+	}
+
+	return float64(C.unqlite_value_to_double(v.v))
+}
+
+// String returns the value cast to string.
+func (v Value) String() string {
+	if v.v == nil {
+		return ""
+	}
+
+	var n C.int
+	s := C.unqlite_value_to_string(v.v, &n)
+
+	return C.GoStringN(s, n)
+}
+
+// Bytes returns the value cast to string as a byte slice.
+func (v Value) Bytes() []byte {
+	if v.v == nil {
+		return nil
+	}
+
+	var n C.int
+	s := C.unqlite_value_to_string(v.v, &n)
+
+	return C.GoBytes(unsafe.Pointer(s), n)
+}
+
+// Len returns the number of elements of an array or object, zero for other values.
+func (v Value) Len() int {
+	if !v.IsArray() {
+		return 0
+	}
+
+	return int(C.unqlite_array_count(v.v))
+}
+
+// Get returns the element of an object stored under the string key key,
+// the zero Value if there is no such element. The engine only looks up string
+// keys, elements stored under integer keys are reached with Walk or Interface.
+func (v Value) Get(key string) Value {
+	if !v.IsArray() {
+		return Value{}
+	}
+
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+
+	return Value{v: C.unqlite_array_fetch(v.v, ckey, C.int(len(key)))}
+}
+
+// Walk calls fn for each element of an array or object in insertion order,
+// until fn returns false. The key and value passed to fn are copies only valid
+// during the call. It returns ErrInvalid if the value is not an array.
+func (v Value) Walk(fn func(key, value Value) bool) error {
+	if !v.IsArray() {
+		return ErrInvalid
+	}
+
+	h := cgo.NewHandle(fn)
+	defer h.Delete()
+
+	// An abort only means fn stopped the walk.
+	res := C.value_walk(v.v, C.uintptr_t(h))
+	if res != C.UNQLITE_OK && res != C.UNQLITE_ABORT {
+		return UnQLiteError(res)
+	}
+
+	return nil
+}
+
+//export goWalkValue
+func goWalkValue(key, value *C.unqlite_value, handle C.uintptr_t) C.int {
+	fn := cgo.Handle(handle).Value().(func(key, value Value) bool)
+	if !fn(Value{v: key}, Value{v: value}) {
+		return C.UNQLITE_ABORT
+	}
+
+	return C.UNQLITE_OK
+}
+
+// Interface converts the value into a Go value: nil, bool, int64, float64, string,
+// []interface{} for arrays or map[string]interface{} for objects.
+// Resources and other values convert to nil.
+func (v Value) Interface() interface{} {
+	switch {
+	case v.v == nil || v.IsNull():
+		return nil
+	case v.IsBool():
+		return v.Bool()
+	case v.IsInt():
+		return v.Int64()
+	case v.IsFloat():
+		return v.Float64()
+	case v.IsString():
+		return v.String()
+	case v.IsObject():
+		m := make(map[string]interface{}, v.Len())
+		v.Walk(func(key, value Value) bool {
+			m[key.String()] = value.Interface()
+			return true
+		})
+		return m
+	case v.IsArray():
+		a := make([]interface{}, 0, v.Len())
+		v.Walk(func(key, value Value) bool {
+			a = append(a, value.Interface())
+			return true
+		})
+		return a
+	}
+
+	return nil
+}
+
+// Decode stores the value into dst, a pointer to a Go value.
+// It follows the rules of encoding/json, so structs are decoded using their json tags.
+func (v Value) Decode(dst interface{}) error {
+	if p, ok := dst.(*interface{}); ok {
+		*p = v.Interface()
+		return nil
+	}
+
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, dst)
+}
+
+// setScalar stores the Go scalar x into the value.
+func setScalar(v *C.unqlite_value, x interface{}) error {
+	var res C.int
+
+	switch x := x.(type) {
+	case nil:
+		res = C.unqlite_value_null(v)
+	case bool:
+		var b C.int
+		if x {
+			b = 1
+		}
+		res = C.unqlite_value_bool(v, b)
+	case int:
+		res = C.unqlite_value_int64(v, C.unqlite_int64(x))
+	case int8:
+		res = C.unqlite_value_int64(v, C.unqlite_int64(x))
+	case int16:
+		res = C.unqlite_value_int64(v, C.unqlite_int64(x))
+	case int32:
+		res = C.unqlite_value_int64(v, C.unqlite_int64(x))
+	case int64:
+		res = C.unqlite_value_int64(v, C.unqlite_int64(x))
+	case uint:
+		if uint64(x) > math.MaxInt64 {
+			return ErrUnsupportedType
+		}
+		res = C.unqlite_value_int64(v, C.unqlite_int64(x))
+	case uint8:
+		res = C.unqlite_value_int64(v, C.unqlite_int64(x))
+	case uint16:
+		res = C.unqlite_value_int64(v, C.unqlite_int64(x))
+	case uint32:
+		res = C.unqlite_value_int64(v, C.unqlite_int64(x))
+	case uint64:
+		if x > math.MaxInt64 {
+			return ErrUnsupportedType
+		}
+		res = C.unqlite_value_int64(v, C.unqlite_int64(x))
+	case float32:
+		res = C.unqlite_value_double(v, C.double(x))
+	case float64:
+		res = C.unqlite_value_double(v, C.double(x))
+	case string:
+		res = setString(v, x)
+	case []byte:
+		res = setString(v, string(x))
+	default:
+		return ErrUnsupportedType
+	}
+
+	if res != C.UNQLITE_OK {
+		return UnQLiteError(res)
+	}
+
+	return nil
+}
+
+// setString stores s into the value.
+func setString(v *C.unqlite_value, s string) C.int {
+	// Reset to an empty string first, unqlite_value_string appends.
+	C.unqlite_value_null(v)
+
+	if len(s) == 0 {
+		return C.unqlite_value_string(v, nil, 0)
+	}
+
+	cs := C.CString(s)
+	defer C.free(unsafe.Pointer(cs))
+
+	return C.unqlite_value_string(v, cs, C.int(len(s)))
+}
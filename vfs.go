@@ -0,0 +1,374 @@
+package unqlitego
+
+// #include <unqlite.h>
+// #include <wrappers.h>
+// #include <stdlib.h>
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"runtime/cgo"
+	"time"
+	"unsafe"
+)
+
+// AccessFlag is the kind of access tested by VFS.Access.
+type AccessFlag int
+
+// Access flags, see the UNQLITE_ACCESS_* flags of unqlite.h.
+const (
+	AccessExists    AccessFlag = C.UNQLITE_ACCESS_EXISTS
+	AccessReadWrite AccessFlag = C.UNQLITE_ACCESS_READWRITE
+	AccessRead      AccessFlag = C.UNQLITE_ACCESS_READ
+)
+
+// LockLevel is the level of a file lock, the engine only ever raises or lowers
+// a lock one level at a time from LockShared on.
+type LockLevel int
+
+// Lock levels, see the UNQLITE_LOCK_* flags of unqlite.h.
+const (
+	LockNone      LockLevel = C.UNQLITE_LOCK_NONE
+	LockShared    LockLevel = C.UNQLITE_LOCK_SHARED
+	LockReserved  LockLevel = C.UNQLITE_LOCK_RESERVED
+	LockPending   LockLevel = C.UNQLITE_LOCK_PENDING
+	LockExclusive LockLevel = C.UNQLITE_LOCK_EXCLUSIVE
+)
+
+// VFS is a virtual file system the engine performs all database and journal file I/O through.
+//
+// Methods may return an UnQLiteError to control the code reported to the engine,
+// i.e: ErrBusy from File.Lock when the lock is held by another process.
+// Any other error is reported as ErrIO.
+type VFS interface {
+	// Open opens the file name with the UNQLITE_OPEN_* flags of mode.
+	Open(name string, mode Mode) (File, error)
+
+	// Delete removes the file name, syncDir requests the directory to be synced afterwards.
+	Delete(name string, syncDir bool) error
+
+	// Access reports whether the file name permits the access flag.
+	// With AccessExists an empty file is reported as missing.
+	Access(name string, flag AccessFlag) (bool, error)
+
+	// FullPathname returns the absolute path of name.
+	FullPathname(name string) (string, error)
+
+	// TmpDir returns the directory for temporary files.
+	TmpDir() string
+
+	// Sleep suspends the calling thread for at least d.
+	Sleep(d time.Duration)
+
+	// CurrentTime returns the current time, it is recorded in the database header.
+	CurrentTime() time.Time
+}
+
+// File is a file opened by a VFS. Reads and writes are positional.
+// A read past the end of the file returns the bytes read and io.EOF.
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+
+	// Truncate changes the size of the file.
+	Truncate(size int64) error
+
+	// Sync commits the content of the file to stable storage.
+	Sync() error
+
+	// FileSize returns the size of the file.
+	FileSize() (int64, error)
+
+	// Lock raises the lock on the file to level.
+	Lock(level LockLevel) error
+
+	// Unlock lowers the lock on the file to level.
+	Unlock(level LockLevel) error
+}
+
+// ReservedLockChecker is implemented by a File which can report if any process,
+// including this one, holds a LockReserved or higher on the file.
+// Files which do not implement it report no lock.
+type ReservedLockChecker interface {
+	CheckReservedLock() (bool, error)
+}
+
+// SetVFS installs vfs as the virtual file system of the library, a nil vfs
+// restores the file system of the operating system. The library can not be
+// configured once it is initialized, which happens when the first database is
+// opened, so close all databases and call Shutdown first. Otherwise ErrLocked
+// is returned.
+func (l *Library) SetVFS(vfs VFS) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.init {
+		// Databases may be using the installed VFS and its handle
+		return newError("SetVFS", nil, C.UNQLITE_LOCKED)
+	}
+
+	if vfs == nil {
+		vfs = osVFS{}
+	}
+
+	return l.configureVFS(vfs)
+}
+
+// configureVFS installs vfs for the files of the library, the library is locked.
+func (l *Library) configureVFS(vfs VFS) error {
+	h := cgo.NewHandle(vfs)
+
+	res := C.lib_config_vfs(C.uintptr_t(h))
+	if res != C.UNQLITE_OK {
+		h.Delete()
+		return newError("SetVFS", nil, res)
+	}
+
+	if l.vfs != 0 {
+		l.vfs.Delete()
+	}
+	l.vfs = h
+
+	return nil
+}
+
+// WithVFS opens the database and its journal through vfs instead of the VFS of the library.
+// It can not be combined with MMap, the memory view bypasses the VFS.
+func WithVFS(vfs VFS) Option {
+	return func(o *options) {
+		o.vfs = vfs
+	}
+}
+
+// routeMark delimits the route of a file name to the VFS of its database, see wrappers.c.
+const routeMark = "\x01"
+
+// routePattern matches the routes in the error log of the engine.
+var routePattern = regexp.MustCompile(routeMark + "[0-9a-f]+" + routeMark)
+
+// routedName returns filename routed to vfs by the VFS of the library.
+func routedName(filename string, vfs *C.unqlite_vfs) string {
+	return fmt.Sprintf("%s%x%s%s", routeMark, uintptr(unsafe.Pointer(vfs)), routeMark, filename)
+}
+
+// dbVFS is the Go VFS of a single database.
+type dbVFS struct {
+	vfs    *C.unqlite_vfs
+	handle cgo.Handle
+}
+
+// newDBVFS creates the engine VFS backed by vfs.
+func newDBVFS(vfs VFS) (*dbVFS, error) {
+	v := &dbVFS{handle: cgo.NewHandle(vfs)}
+	v.vfs = C.go_vfs_new(C.uintptr_t(v.handle))
+	if v.vfs == nil {
+		v.handle.Delete()
+		return nil, newError("Open", nil, C.UNQLITE_NOMEM)
+	}
+
+	return v, nil
+}
+
+// release frees the VFS once the database is closed.
+func (v *dbVFS) release() {
+	C.free(unsafe.Pointer(v.vfs))
+	v.handle.Delete()
+}
+
+// vfsResult converts an error of a VFS or File into the code reported to the engine.
+func vfsResult(err error) C.int {
+	if err == nil {
+		return C.UNQLITE_OK
+	}
+
+	var code UnQLiteError
+	if errors.As(err, &code) && code < 0 {
+		return C.int(code)
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return C.UNQLITE_PERM
+	}
+
+	return C.UNQLITE_IOERR
+}
+
+// copyCString copies s into the C buffer buf of n bytes as a NUL terminated string.
+func copyCString(buf *C.char, n C.int, s string) C.int {
+	if len(s) >= int(n) {
+		return C.UNQLITE_FULL
+	}
+
+	b := unsafe.Slice((*byte)(unsafe.Pointer(buf)), int(n))
+	b[copy(b, s)] = 0
+
+	return C.UNQLITE_OK
+}
+
+//export goVFSOpen
+func goVFSOpen(vfs C.uintptr_t, name *C.char, flags C.uint, handle *C.uintptr_t) C.int {
+	f, err := cgo.Handle(vfs).Value().(VFS).Open(C.GoString(name), Mode(flags))
+	if err != nil {
+		return vfsResult(err)
+	}
+	*handle = C.uintptr_t(cgo.NewHandle(f))
+
+	return C.UNQLITE_OK
+}
+
+//export goVFSDelete
+func goVFSDelete(vfs C.uintptr_t, name *C.char, syncDir C.int) C.int {
+	return vfsResult(cgo.Handle(vfs).Value().(VFS).Delete(C.GoString(name), syncDir != 0))
+}
+
+//export goVFSAccess
+func goVFSAccess(vfs C.uintptr_t, name *C.char, flags C.int, res *C.int) C.int {
+	ok, err := cgo.Handle(vfs).Value().(VFS).Access(C.GoString(name), AccessFlag(flags))
+	if err != nil {
+		return vfsResult(err)
+	}
+
+	*res = 0
+	if ok {
+		*res = 1
+	}
+
+	return C.UNQLITE_OK
+}
+
+//export goVFSFullPathname
+func goVFSFullPathname(vfs C.uintptr_t, name *C.char, buf *C.char, n C.int) C.int {
+	p, err := cgo.Handle(vfs).Value().(VFS).FullPathname(C.GoString(name))
+	if err != nil {
+		return vfsResult(err)
+	}
+
+	return copyCString(buf, n, p)
+}
+
+//export goVFSTmpDir
+func goVFSTmpDir(vfs C.uintptr_t, buf *C.char, n C.int) C.int {
+	return copyCString(buf, n, cgo.Handle(vfs).Value().(VFS).TmpDir())
+}
+
+//export goVFSSleep
+func goVFSSleep(vfs C.uintptr_t, us C.int) C.int {
+	cgo.Handle(vfs).Value().(VFS).Sleep(time.Duration(us) * time.Microsecond)
+
+	return us
+}
+
+//export goVFSCurrentTime
+func goVFSCurrentTime(vfs C.uintptr_t, out *C.Sytm) C.int {
+	t := cgo.Handle(vfs).Value().(VFS).CurrentTime().UTC()
+
+	// Set the fields one by one, the structure may be unaligned
+	out.tm_sec = C.int(t.Second())
+	out.tm_min = C.int(t.Minute())
+	out.tm_hour = C.int(t.Hour())
+	out.tm_mday = C.int(t.Day())
+	out.tm_mon = C.int(t.Month() - 1)
+	out.tm_year = C.int(t.Year())
+	out.tm_wday = C.int(t.Weekday())
+	out.tm_yday = C.int(t.YearDay() - 1)
+
+	return C.UNQLITE_OK
+}
+
+//export goFileClose
+func goFileClose(handle C.uintptr_t) C.int {
+	h := cgo.Handle(handle)
+	defer h.Delete()
+
+	return vfsResult(h.Value().(File).Close())
+}
+
+//export goFileRead
+func goFileRead(handle C.uintptr_t, buf unsafe.Pointer, n, off C.unqlite_int64) C.int {
+	b := unsafe.Slice((*byte)(buf), int(n))
+
+	read, err := cgo.Handle(handle).Value().(File).ReadAt(b, int64(off))
+	if read == len(b) {
+		return C.UNQLITE_OK
+	}
+
+	// Unread parts of the buffer must be zero-filled
+	for i := read; i < len(b); i++ {
+		b[i] = 0
+	}
+	if err == nil || err == io.EOF {
+		return C.UNQLITE_IOERR
+	}
+
+	return vfsResult(err)
+}
+
+//export goFileWrite
+func goFileWrite(handle C.uintptr_t, buf unsafe.Pointer, n, off C.unqlite_int64) C.int {
+	b := unsafe.Slice((*byte)(buf), int(n))
+
+	written, err := cgo.Handle(handle).Value().(File).WriteAt(b, int64(off))
+	if err != nil {
+		return vfsResult(err)
+	}
+	if written != len(b) {
+		return C.UNQLITE_FULL
+	}
+
+	return C.UNQLITE_OK
+}
+
+//export goFileTruncate
+func goFileTruncate(handle C.uintptr_t, size C.unqlite_int64) C.int {
+	return vfsResult(cgo.Handle(handle).Value().(File).Truncate(int64(size)))
+}
+
+//export goFileSync
+func goFileSync(handle C.uintptr_t, flags C.int) C.int {
+	return vfsResult(cgo.Handle(handle).Value().(File).Sync())
+}
+
+//export goFileSize
+func goFileSize(handle C.uintptr_t, size *C.unqlite_int64) C.int {
+	n, err := cgo.Handle(handle).Value().(File).FileSize()
+	if err != nil {
+		return vfsResult(err)
+	}
+	*size = C.unqlite_int64(n)
+
+	return C.UNQLITE_OK
+}
+
+//export goFileLock
+func goFileLock(handle C.uintptr_t, level C.int) C.int {
+	return vfsResult(cgo.Handle(handle).Value().(File).Lock(LockLevel(level)))
+}
+
+//export goFileUnlock
+func goFileUnlock(handle C.uintptr_t, level C.int) C.int {
+	return vfsResult(cgo.Handle(handle).Value().(File).Unlock(LockLevel(level)))
+}
+
+//export goFileCheckReservedLock
+func goFileCheckReservedLock(handle C.uintptr_t, res *C.int) C.int {
+	*res = 0
+
+	c, ok := cgo.Handle(handle).Value().(ReservedLockChecker)
+	if !ok {
+		return C.UNQLITE_OK
+	}
+
+	locked, err := c.CheckReservedLock()
+	if err != nil {
+		return vfsResult(err)
+	}
+	if locked {
+		*res = 1
+	}
+
+	return C.UNQLITE_OK
+}
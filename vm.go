@@ -6,13 +6,56 @@ package unqlitego
 import "C"
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"runtime/cgo"
+	"strings"
+	"sync"
 	"unsafe"
 )
 
 // VM Represents an UnQLite/Jx9 Virtual Machine.
 type VM struct {
 	vm *C.unqlite_vm
+
+	// Database the VM is compiled against
+	db *Database
+
+	// Program the VM was acquired from, if any
+	prog *Program
+
+	// VM is released to its program, guarded by prog.mu
+	idle bool
+
+	// Registered foreign functions
+	funcs map[string]cgo.Handle
+
+	// Defined constants
+	consts map[string]cgo.Handle
+
+	// Failure of the Go callbacks during the execution
+	state *callbackState
+
+	// Names of the created variables, the engine keeps pointers to them
+	vars map[string]*C.char
+
+	// Output consumer installed by SetOutput and its handle
+	out       *consumer
+	outHandle cgo.Handle
+
+	// Runtime messages collected after EnableErrorReport and their consumer handle
+	errs       *diagnostics
+	errsHandle cgo.Handle
+
+	// Import paths, the engine keeps pointers to them
+	paths []*C.char
+
+	// File system the script was compiled from, zero for the OS file system
+	fsys cgo.Handle
 }
 
 // NewVM creates and intializes a new UnQlite/Jx9 Virtual Machine.
@@ -21,19 +64,238 @@ func NewVM() (vm *VM) {
 	return
 }
 
+// Close releases the Virtual Machine with its registered functions and variables.
+// It is called by the garbage collector for VMs which are not closed.
+func (vm *VM) Close() (err error) {
+	runtime.SetFinalizer(vm, nil)
+
+	if vm.vm != nil && vm.db != nil {
+		res := vm.db.releaseVM(vm.vm)
+		if res != C.UNQLITE_OK {
+			err = newError("Close", nil, res)
+		}
+	}
+	vm.vm = nil
+	vm.db = nil
+
+	vm.releaseFuncs()
+	vm.releaseConsts()
+	vm.releaseVars()
+	vm.releaseOutput()
+	vm.releaseErrorReport()
+	vm.releasePaths()
+
+	return
+}
+
 // Free releases the Virtual Machine.
-func (vm VM) Free() {
-	C.free(unsafe.Pointer(vm.vm))
+//
+// Deprecated: Use Close.
+func (vm *VM) Free() {
+	vm.Close()
+}
+
+// Reset prepares the Virtual Machine to execute the compiled program again.
+// Its output and global variables are discarded, foreign functions, variables
+// created with SetVar and the output set with SetOutput are kept.
+func (vm *VM) Reset() error {
+	if vm.vm == nil {
+		return newError("Reset", nil, C.UNQLITE_CORRUPT)
+	}
+
+	res := C.unqlite_vm_reset(vm.vm)
+	if res != C.UNQLITE_OK {
+		return newError("Reset", nil, res)
+	}
+
+	if vm.out != nil {
+		vm.out.err = nil
+	}
+	if vm.errs != nil {
+		vm.errs.list = nil
+	}
+
+	return nil
+}
+
+// clear resets the VM for another user: the variables set with SetVar are reset to
+// null and the constants are deleted. The engine keeps the names of the variables.
+func (vm *VM) clear() error {
+	if err := vm.Reset(); err != nil {
+		return err
+	}
+
+	for name := range vm.vars {
+		if err := vm.SetVar(name, nil); err != nil {
+			return err
+		}
+	}
+
+	for name := range vm.consts {
+		if err := vm.DeleteConstant(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Execute Virtual Machine.
+// It returns the native result code of the execution, use Run for an error
+// reporting the script errors and the output errors as well.
 func (vm *VM) Execute() int {
-	res := C.unqlite_vm_exec(vm.vm)
+	var code UnQLiteError
+	if errors.As(vm.Run(), &code) {
+		return int(code)
+	}
+
+	return C.UNQLITE_OK
+}
+
+// Run executes the Virtual Machine.
+// With EnableErrorReport a *RuntimeError is returned when the script raised errors.
+func (vm *VM) Run() error {
+	return vm.ExecuteContext(context.Background())
+}
+
+// ExecuteContext executes the Virtual Machine until the program completes or the
+// context is done. An interrupted program stops at its next instruction and an
+// error wrapping ErrAborted is returned, ctx.Err() tells whether it was cancelled
+// or its deadline passed. A program which completed is never reported as aborted.
+// A program aborted by a foreign function or a constant returns a *CallbackError.
+//
+// When no transaction was open before the program ran, the writes it performed
+// before it was interrupted are rolled back and the database is left as it was.
+// Writes made in a transaction which was already open, such as a Tx, cannot be told
+// apart from those of the caller: they are left in it, to be committed or rolled back
+// with it. In-memory databases do not support rollbacks.
+// The VM must be reset before it is executed again.
+func (vm *VM) ExecuteContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return &Error{Op: "Execute", Code: ErrAborted, Log: err.Error()}
+	}
+
+	// The program is only interrupted while it runs, so the request never
+	// outlives the execution.
+	var mu sync.Mutex
+	running := true
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	if ctx.Done() == nil {
+		// The context is never done.
+		close(done)
+	} else {
+		go func() {
+			defer close(done)
+			select {
+			case <-ctx.Done():
+				mu.Lock()
+				if running {
+					C.unqlite_vm_interrupt(vm.vm)
+				}
+				mu.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+
+	if vm.errs != nil {
+		vm.errs.list = nil
+	}
+	if vm.state != nil {
+		vm.state.err = nil
+	}
+	res := C.vm_exec(vm.vm, C.uintptr_t(vm.fsys))
+	mu.Lock()
+	running = false
+	mu.Unlock()
+	close(stop)
+	<-done
+
+	if vm.state != nil && vm.state.err != nil {
+		return vm.state.err
+	}
+	if res == C.UNQLITE_ABORT {
+		return &Error{Op: "Execute", Code: ErrAborted, Log: ctx.Err().Error()}
+	}
+	if res != C.UNQLITE_OK {
+		return newError("Execute", nil, res)
+	}
+	if vm.errs != nil {
+		if err := vm.errs.err(); err != nil {
+			return err
+		}
+	}
+
+	return vm.OutputErr()
+}
+
+// ExecuteArgs executes the Virtual Machine with args as the script arguments,
+// $argv, and returns the value of the script return statement (null without one).
+// The Value is valid until the VM is reset or released.
+func (vm *VM) ExecuteArgs(args ...string) (Value, error) {
+	// Arguments of a previous execution are kept by the engine.
+	res := C.vm_config_argv(vm.vm, nil)
+	if res != C.UNQLITE_OK {
+		return Value{}, newError("ExecuteArgs", nil, res)
+	}
+
+	for _, arg := range args {
+		carg := C.CString(arg)
+		res := C.vm_config_argv(vm.vm, carg)
+		C.free(unsafe.Pointer(carg))
+		if res != C.UNQLITE_OK {
+			return Value{}, newError("ExecuteArgs", []byte(arg), res)
+		}
+	}
+
+	if err := vm.Run(); err != nil {
+		return Value{}, err
+	}
+
+	return Value{v: C.vm_exec_value(vm.vm)}, nil
+}
+
+// EnableErrorReport reports the runtime errors, warnings and notices of the script,
+// which are otherwise ignored. They are kept apart from the output: Run returns
+// a *RuntimeError when the script raised errors and Diagnostics lists every message.
+func (vm *VM) EnableErrorReport() error {
+	if vm.errs != nil {
+		return nil
+	}
+
+	d := &diagnostics{}
+	_, h := newConsumer(d)
+	res := C.vm_config_err_report(vm.vm, C.uintptr_t(h))
+	if res != C.UNQLITE_OK {
+		h.Delete()
+		return newError("EnableErrorReport", nil, res)
+	}
+	vm.errs, vm.errsHandle = d, h
+
+	return nil
+}
+
+// Diagnostics returns the runtime messages of the last execution, nil unless
+// EnableErrorReport was called.
+func (vm *VM) Diagnostics() []Diagnostic {
+	if vm.errs == nil {
+		return nil
+	}
 
-	return int(res)
+	return vm.errs.list
+}
+
+// releaseErrorReport deletes the handle of the error consumer.
+func (vm *VM) releaseErrorReport() {
+	if vm.errs != nil {
+		vm.errsHandle.Delete()
+		vm.errs, vm.errsHandle = nil, 0
+	}
 }
 
 // Result will return the output of the Virtual Machine after execution.
+// It is empty when the output is streamed with SetOutput.
 func (vm *VM) Result() string {
 	var len C.int
 	var buff *C.char
@@ -44,6 +306,144 @@ func (vm *VM) Result() string {
 	return fmt.Sprintf("%s", q)
 }
 
+// SetOutput streams the output of the script (print, echo, ...) to w as it runs,
+// instead of buffering it for Result. A nil w discards the output.
+// When writing to w fails the script is aborted, the error is reported by OutputErr.
+func (vm *VM) SetOutput(w io.Writer) error {
+	if w == nil {
+		w = ioutil.Discard
+	}
+
+	c, h := newConsumer(w)
+	res := C.vm_config_output(vm.vm, C.uintptr_t(h))
+	if res != C.UNQLITE_OK {
+		h.Delete()
+		return newError("SetOutput", nil, res)
+	}
+
+	vm.releaseOutput()
+	vm.out, vm.outHandle = c, h
+
+	return nil
+}
+
+// Dump writes the disassembly of the compiled program (the JX9 bytecode) to w.
+func (vm *VM) Dump(w io.Writer) error {
+	c, h := newConsumer(w)
+	defer h.Delete()
+
+	res := C.vm_dump_to(vm.vm, C.uintptr_t(h))
+	if c.err != nil {
+		return c.err
+	}
+	if res != C.UNQLITE_OK {
+		return newError("Dump", nil, res)
+	}
+
+	return nil
+}
+
+// OutputErr returns the first error writing the output set with SetOutput.
+func (vm *VM) OutputErr() error {
+	if vm.out == nil {
+		return nil
+	}
+
+	return vm.out.err
+}
+
+// releaseOutput deletes the handle of the output consumer.
+func (vm *VM) releaseOutput() {
+	if vm.out != nil {
+		vm.outHandle.Delete()
+		vm.out, vm.outHandle = nil, 0
+	}
+}
+
+// AddImportPath adds dir to the directories searched for relative file names
+// by include and import. For scripts compiled with CompileFS dir is a directory of the fs.FS.
+func (vm *VM) AddImportPath(dir string) error {
+	cdir := C.CString(dir)
+
+	res := C.vm_config_import_path(vm.vm, cdir)
+	if res != C.UNQLITE_OK {
+		C.free(unsafe.Pointer(cdir))
+		return newError("AddImportPath", []byte(dir), res)
+	}
+	vm.paths = append(vm.paths, cdir)
+
+	return nil
+}
+
+// releasePaths frees the import paths and the file system handle.
+func (vm *VM) releasePaths() {
+	for _, p := range vm.paths {
+		C.free(unsafe.Pointer(p))
+	}
+	vm.paths = nil
+
+	if vm.fsys != 0 {
+		vm.fsys.Delete()
+		vm.fsys = 0
+	}
+}
+
+// SetVar creates or replaces the JX9 variable $name with the value x before execution.
+//
+// Scalars (nil, bool, integers, floats, string and []byte) map to their JX9 counterpart,
+// slices and arrays to JSON arrays, maps to JSON objects and structs are converted
+// through encoding/json, so json tags are honored. The variable holds a copy of x.
+func (vm *VM) SetVar(name string, x interface{}) error {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "$")
+	if name == "" {
+		return ErrInvalid
+	}
+
+	a := vmAllocator{vm: vm.vm}
+
+	v, ok := x.(Value)
+	if !ok || v.v == nil {
+		if ok {
+			x = nil
+		}
+		cv, err := newValue(a, x)
+		if err != nil {
+			return err
+		}
+		defer a.release(cv)
+
+		v = Value{v: cv}
+	}
+
+	cname, ok := vm.vars[name]
+	if !ok {
+		cname = C.CString(name)
+	}
+
+	res := C.vm_create_var(vm.vm, cname, v.v)
+	if res != C.UNQLITE_OK {
+		if !ok {
+			C.free(unsafe.Pointer(cname))
+		}
+		return newError("SetVar", []byte(name), res)
+	}
+
+	if vm.vars == nil {
+		vm.vars = make(map[string]*C.char)
+	}
+	vm.vars[name] = cname
+
+	return nil
+}
+
+// releaseVars frees the names of the created variables.
+func (vm *VM) releaseVars() {
+	for name, cname := range vm.vars {
+		C.free(unsafe.Pointer(cname))
+		delete(vm.vars, name)
+	}
+}
+
 /*
 	This function must be used with extra causion since it might return
 	a variable from the type of *C.unqlite_value ,be sure to free this pointer
@@ -71,6 +471,30 @@ func (vm *VM) extract(v string) *unQLiteValue {
 	return uval
 }
 
+// Extract returns the variable $name of the executed Virtual Machine.
+// The Value is owned by the VM and valid until it is reset or released.
+func (vm *VM) Extract(name string) (Value, error) {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "$")
+
+	uval := vm.extract(name)
+	if uval.Nil() {
+		return Value{}, newError("Extract", []byte(name), C.UNQLITE_NOTFOUND)
+	}
+
+	return Value{v: uval.v}, nil
+}
+
+// ExtractInto decodes the variable $name of the executed Virtual Machine into dst,
+// which can be a map[string]interface{}, a slice or a struct with json tags.
+func (vm *VM) ExtractInto(name string, dst interface{}) error {
+	v, err := vm.Extract(name)
+	if err != nil {
+		return err
+	}
+
+	return v.Decode(dst)
+}
+
 // ExtractInt will extract the result from the Virtual Machine as int.
 func (vm *VM) ExtractInt(v string) (int, error) {
 	/*
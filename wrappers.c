@@ -7,43 +7,964 @@ execute or call functions directly
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
+#include "_cgo_export.h"
 
 #define VERSION "1.0"
 #ifdef __cplusplus
 extern "C" {
 #endif
 
-char * extract_unqlite_log_error(unqlite *pDb) {
-    /*
-    This function will return the number of chars at the error message
-    If there is no error message than 0 is returned
-    */
-    int length;
-    char *buffer;
+int config_max_page_cache(unqlite *pDb, int nMaxPage) {
+    return unqlite_config(pDb, UNQLITE_CONFIG_MAX_PAGE_CACHE, nMaxPage);
+}
+
+int config_kv_engine(unqlite *pDb, const char *zKvName) {
+    return unqlite_config(pDb, UNQLITE_CONFIG_KV_ENGINE, zKvName);
+}
+
+int config_disable_auto_commit(unqlite *pDb) {
+    return unqlite_config(pDb, UNQLITE_CONFIG_DISABLE_AUTO_COMMIT);
+}
+
+int config_get_kv_name(unqlite *pDb, const char **pzPtr) {
+    return unqlite_config(pDb, UNQLITE_CONFIG_GET_KV_NAME, pzPtr);
+}
+
+int config_err_log(unqlite *pDb, const char **pzBuf, int *pLen) {
+    return unqlite_config(pDb, UNQLITE_CONFIG_ERR_LOG, pzBuf, pLen);
+}
+
+int config_err_log_reset(unqlite *pDb) {
+    return unqlite_config(pDb, UNQLITE_CONFIG_ERR_LOG_RESET);
+}
 
-    //Extract the errror if exists
-    unqlite_config(pDb, UNQLITE_CONFIG_JX9_ERR_LOG, &buffer, &length);
-    
-    return buffer;
+int config_jx9_err_log(unqlite *pDb, const char **pzBuf, int *pLen) {
+    return unqlite_config(pDb, UNQLITE_CONFIG_JX9_ERR_LOG, pzBuf, pLen);
 }
 
-char * extract_vm_output(unqlite_vm *pvm, int *length) {
-    const void *buffer;
-    char *t;
+/*
+The Key/Value comparison and hash callbacks do not take any user data,
+so each database gets a slot with its own pair of trampolines into Go.
+*/
+#define KV_SLOT(N) \
+static int kv_cmp_##N(const void *pKey1, const void *pKey2, unsigned int nLen) { \
+    return goKVCompare(N, (void *)pKey1, (void *)pKey2, nLen); \
+} \
+static unsigned int kv_hash_##N(const void *pKey, unsigned int nLen) { \
+    return goKVHash(N, (void *)pKey, nLen); \
+}
+
+KV_SLOT(0) KV_SLOT(1) KV_SLOT(2) KV_SLOT(3)
+KV_SLOT(4) KV_SLOT(5) KV_SLOT(6) KV_SLOT(7)
+KV_SLOT(8) KV_SLOT(9) KV_SLOT(10) KV_SLOT(11)
+KV_SLOT(12) KV_SLOT(13) KV_SLOT(14) KV_SLOT(15)
+
+static int (*kv_cmp_slots[KV_SLOTS])(const void *, const void *, unsigned int) = {
+    kv_cmp_0, kv_cmp_1, kv_cmp_2, kv_cmp_3,
+    kv_cmp_4, kv_cmp_5, kv_cmp_6, kv_cmp_7,
+    kv_cmp_8, kv_cmp_9, kv_cmp_10, kv_cmp_11,
+    kv_cmp_12, kv_cmp_13, kv_cmp_14, kv_cmp_15,
+};
+
+static unsigned int (*kv_hash_slots[KV_SLOTS])(const void *, unsigned int) = {
+    kv_hash_0, kv_hash_1, kv_hash_2, kv_hash_3,
+    kv_hash_4, kv_hash_5, kv_hash_6, kv_hash_7,
+    kv_hash_8, kv_hash_9, kv_hash_10, kv_hash_11,
+    kv_hash_12, kv_hash_13, kv_hash_14, kv_hash_15,
+};
+
+int kv_config_cmp_func(unqlite *pDb, int slot) {
+    return unqlite_kv_config(pDb, UNQLITE_KV_CONFIG_CMP_FUNC, kv_cmp_slots[slot]);
+}
+
+int kv_config_hash_func(unqlite *pDb, int slot) {
+    return unqlite_kv_config(pDb, UNQLITE_KV_CONFIG_HASH_FUNC, kv_hash_slots[slot]);
+}
+
+/*
+Consumer callback forwarding chunks to the Go consumer identified by the user data handle.
+*/
+static int consumer_callback(const void *pData, unsigned int nLen, void *pUserData) {
+    return goConsume((void *)pData, nLen, (uintptr_t)pUserData);
+}
+
+int kv_fetch_to(unqlite *pDb, const void *pKey, int nKeyLen, uintptr_t handle) {
+    return unqlite_kv_fetch_callback(pDb, pKey, nKeyLen, consumer_callback, (void *)handle);
+}
+
+int kv_cursor_key_to(unqlite_kv_cursor *pCursor, uintptr_t handle) {
+    return unqlite_kv_cursor_key_callback(pCursor, consumer_callback, (void *)handle);
+}
+
+int kv_cursor_data_to(unqlite_kv_cursor *pCursor, uintptr_t handle) {
+    return unqlite_kv_cursor_data_callback(pCursor, consumer_callback, (void *)handle);
+}
+
+/*
+Foreign function trampoline, the user data is the handle of the Go function.
+*/
+static int foreign_function(unqlite_context *pCtx, int argc, unqlite_value **argv) {
+    return goForeignFunction(pCtx, argc, argv, (uintptr_t)unqlite_context_user_data(pCtx));
+}
+
+int vm_create_function(unqlite_vm *pVm, const char *zName, uintptr_t handle) {
+    return unqlite_create_function(pVm, zName, foreign_function, (void *)handle);
+}
+
+int vm_create_var(unqlite_vm *pVm, const char *zName, unqlite_value *pValue) {
+    return unqlite_vm_config(pVm, UNQLITE_VM_CONFIG_CREATE_VAR, zName, pValue);
+}
+
+static int walk_callback(unqlite_value *pKey, unqlite_value *pValue, void *pUserData) {
+    return goWalkValue(pKey, pValue, (uintptr_t)pUserData);
+}
 
-    //Extract the VM output
+int value_walk(unqlite_value *pArray, uintptr_t handle) {
+    return unqlite_array_walk(pArray, walk_callback, (void *)handle);
+}
+
+const char * extract_vm_output(unqlite_vm *pvm, int *length) {
+    const void *buffer = 0;
+
+    //Extract the VM output, owned by the VM
+    *length = 0;
     unqlite_vm_config(pvm, UNQLITE_VM_CONFIG_EXTRACT_OUTPUT, &buffer, length);
-    t = (char *)malloc(*length + 1);
-    memcpy(t, (const char*)buffer, *length);
 
-    return t;
+    return (const char *)buffer;
+}
+
+int vm_config_output(unqlite_vm *pVm, uintptr_t handle) {
+    return unqlite_vm_config(pVm, UNQLITE_VM_CONFIG_OUTPUT, consumer_callback, (void *)handle);
+}
+
+int vm_config_import_path(unqlite_vm *pVm, const char *zPath) {
+    return unqlite_vm_config(pVm, UNQLITE_VM_CONFIG_IMPORT_PATH, zPath);
+}
+
+/*
+IO stream device as declared by the engine, unqlite.h only exposes the type name.
+*/
+struct jx9_io_stream {
+    const char *zName;
+    int iVersion;
+    int  (*xOpen)(const char *, int, unqlite_value *, void **);
+    int  (*xOpenDir)(const char *, unqlite_value *, void **);
+    void (*xClose)(void *);
+    void (*xCloseDir)(void *);
+    unqlite_int64 (*xRead)(void *, void *, unqlite_int64);
+    int (*xReadDir)(void *, unqlite_context *);
+    unqlite_int64 (*xWrite)(void *, const void *, unqlite_int64);
+    int (*xSeek)(void *, unqlite_int64, int);
+    int (*xLock)(void *, int);
+    void (*xRewindDir)(void *);
+    unqlite_int64 (*xTell)(void *);
+    int (*xTrunc)(void *, unqlite_int64);
+    int (*xSync)(void *);
+    int (*xStat)(void *, unqlite_value *, unqlite_value *);
+};
+
+/*
+Handle of the Go file system of the VM executing on this thread, the
+stream callbacks do not receive any user data.
+*/
+static __thread uintptr_t fs_current;
+
+static int fs_open(const char *zPath, int iOpenMode, unqlite_value *pResource, void **ppHandle) {
+    uintptr_t handle = 0;
+    int rc;
+
+    if (fs_current == 0) {
+        return UNQLITE_IOERR;
+    }
+
+    rc = goFSOpen(fs_current, (char *)zPath, iOpenMode, &handle);
+    *ppHandle = (void *)handle;
+
+    return rc;
+}
+
+static void fs_close(void *pHandle) {
+    goFSClose((uintptr_t)pHandle);
+}
+
+static unqlite_int64 fs_read(void *pHandle, void *pBuffer, unqlite_int64 nDatatoRead) {
+    return goFSRead((uintptr_t)pHandle, pBuffer, nDatatoRead);
+}
+
+static int fs_seek(void *pHandle, unqlite_int64 iOfft, int whence) {
+    return goFSSeek((uintptr_t)pHandle, iOfft, whence);
+}
+
+static unqlite_int64 fs_tell(void *pHandle) {
+    return goFSTell((uintptr_t)pHandle);
+}
+
+/*
+The fs:// stream, the file:// stream of the engine stays the default one.
+*/
+static const struct jx9_io_stream fs_stream = {
+    "fs",
+    1,
+    fs_open,
+    0,
+    fs_close,
+    0,
+    fs_read,
+    0,
+    0,
+    fs_seek,
+    0,
+    0,
+    fs_tell,
+    0,
+    0,
+    0
+};
 
+int vm_config_fs_stream(unqlite_vm *pVm) {
+    return unqlite_vm_config(pVm, UNQLITE_VM_CONFIG_IO_STREAM, &fs_stream);
+}
+
+int vm_exec(unqlite_vm *pVm, uintptr_t fs) {
+    uintptr_t prev = fs_current;
+    int rc;
+
+    fs_current = fs;
+    rc = unqlite_vm_exec(pVm);
+    fs_current = prev;
+
+    return rc;
 }
 
 char * extract_variable_as_string(unqlite_value *unqlite_value, int *len) {
     return (unsigned char *)unqlite_value_to_string(unqlite_value, len);
 }
 
+int vm_config_err_report(unqlite_vm *pVm, uintptr_t handle) {
+    int rc;
+
+    rc = unqlite_vm_config(pVm, UNQLITE_VM_CONFIG_ERR_CONSUMER, consumer_callback, (void *)handle);
+    if (rc != UNQLITE_OK) {
+        return rc;
+    }
+
+    return unqlite_vm_config(pVm, UNQLITE_VM_CONFIG_ERR_REPORT);
+}
+
+int vm_config_argv(unqlite_vm *pVm, const char *zValue) {
+    return unqlite_vm_config(pVm, UNQLITE_VM_CONFIG_ARGV_ENTRY, zValue);
+}
+
+unqlite_value * vm_exec_value(unqlite_vm *pVm) {
+    unqlite_value *pValue = 0;
+
+    unqlite_vm_config(pVm, UNQLITE_VM_CONFIG_EXEC_VALUE, &pValue);
+
+    return pValue;
+}
+
+/*
+Constant expansion trampoline, the user data is the handle of the Go function.
+*/
+static void constant_expand(unqlite_value *pValue, void *pUserData) {
+    goExpandConstant(pValue, (uintptr_t)pUserData);
+}
+
+int vm_create_constant(unqlite_vm *pVm, const char *zName, uintptr_t handle) {
+    return unqlite_create_constant(pVm, zName, constant_expand, (void *)handle);
+}
+
+int vm_dump_to(unqlite_vm *pVm, uintptr_t handle) {
+    return unqlite_vm_dump(pVm, consumer_callback, (void *)handle);
+}
+
+int vm_config_http_request(unqlite_vm *pVm, const char *zRequest, int nByte) {
+    return unqlite_vm_config(pVm, UNQLITE_VM_CONFIG_HTTP_REQUEST, zRequest, nByte);
+}
+
+int vm_config_server_attr(unqlite_vm *pVm, const char *zKey, const char *zValue, int nLen) {
+    return unqlite_vm_config(pVm, UNQLITE_VM_CONFIG_SERVER_ATTR, zKey, zValue, nLen);
+}
+
+int vm_config_env_attr(unqlite_vm *pVm, const char *zKey, const char *zValue, int nLen) {
+    return unqlite_vm_config(pVm, UNQLITE_VM_CONFIG_ENV_ATTR, zKey, zValue, nLen);
+}
+
+/*
+Go virtual file system, the VFS carries the handle of the Go VFS.
+*/
+typedef struct go_vfs go_vfs;
+struct go_vfs {
+    unqlite_vfs base;
+    uintptr_t handle;
+};
+
+#define VFS_HANDLE(pVfs) (((go_vfs *)(pVfs))->handle)
+
+typedef struct go_file go_file;
+struct go_file {
+    const unqlite_io_methods *pMethods;
+    uintptr_t handle;
+};
+
+static int file_close(unqlite_file *pFile) {
+    return goFileClose(((go_file *)pFile)->handle);
+}
+
+static int file_read(unqlite_file *pFile, void *pBuf, unqlite_int64 iAmt, unqlite_int64 iOfst) {
+    return goFileRead(((go_file *)pFile)->handle, pBuf, iAmt, iOfst);
+}
+
+static int file_write(unqlite_file *pFile, const void *pBuf, unqlite_int64 iAmt, unqlite_int64 iOfst) {
+    return goFileWrite(((go_file *)pFile)->handle, (void *)pBuf, iAmt, iOfst);
+}
+
+static int file_truncate(unqlite_file *pFile, unqlite_int64 size) {
+    return goFileTruncate(((go_file *)pFile)->handle, size);
+}
+
+static int file_sync(unqlite_file *pFile, int flags) {
+    return goFileSync(((go_file *)pFile)->handle, flags);
+}
+
+static int file_size(unqlite_file *pFile, unqlite_int64 *pSize) {
+    return goFileSize(((go_file *)pFile)->handle, pSize);
+}
+
+static int file_lock(unqlite_file *pFile, int level) {
+    return goFileLock(((go_file *)pFile)->handle, level);
+}
+
+static int file_unlock(unqlite_file *pFile, int level) {
+    return goFileUnlock(((go_file *)pFile)->handle, level);
+}
+
+static int file_check_reserved_lock(unqlite_file *pFile, int *pResOut) {
+    return goFileCheckReservedLock(((go_file *)pFile)->handle, pResOut);
+}
+
+static const unqlite_io_methods file_methods = {
+    1,
+    file_close,
+    file_read,
+    file_write,
+    file_truncate,
+    file_sync,
+    file_size,
+    file_lock,
+    file_unlock,
+    file_check_reserved_lock,
+    0,
+};
+
+static int vfs_open(unqlite_vfs *pVfs, const char *zName, unqlite_file *pFile, unsigned int flags) {
+    uintptr_t handle = 0;
+    int rc;
+
+    rc = goVFSOpen(VFS_HANDLE(pVfs), (char *)zName, flags, &handle);
+    if (rc != UNQLITE_OK) {
+        return rc;
+    }
+    ((go_file *)pFile)->handle = handle;
+    pFile->pMethods = &file_methods;
+
+    return UNQLITE_OK;
+}
+
+static int vfs_delete(unqlite_vfs *pVfs, const char *zName, int syncDir) {
+    return goVFSDelete(VFS_HANDLE(pVfs), (char *)zName, syncDir);
+}
+
+static int vfs_access(unqlite_vfs *pVfs, const char *zName, int flags, int *pResOut) {
+    return goVFSAccess(VFS_HANDLE(pVfs), (char *)zName, flags, pResOut);
+}
+
+static int vfs_full_pathname(unqlite_vfs *pVfs, const char *zName, int buf_len, char *zBuf) {
+    return goVFSFullPathname(VFS_HANDLE(pVfs), (char *)zName, zBuf, buf_len);
+}
+
+static int vfs_tmp_dir(unqlite_vfs *pVfs, char *zBuf, int buf_len) {
+    return goVFSTmpDir(VFS_HANDLE(pVfs), zBuf, buf_len);
+}
+
+static int vfs_sleep(unqlite_vfs *pVfs, int microseconds) {
+    return goVFSSleep(VFS_HANDLE(pVfs), microseconds);
+}
+
+static int vfs_current_time(unqlite_vfs *pVfs, Sytm *pOut) {
+    return goVFSCurrentTime(VFS_HANDLE(pVfs), pOut);
+}
+
+static const unqlite_vfs go_vfs_methods = {
+    "go",
+    1,
+    sizeof(go_file),
+    512,
+    vfs_open,
+    vfs_delete,
+    vfs_access,
+    vfs_full_pathname,
+    vfs_tmp_dir,
+    vfs_sleep,
+    vfs_current_time,
+    0,
+};
+
+/* Go VFS of the files of the library, see route_vfs */
+static unqlite_vfs *lib_vfs;
+
+unqlite_vfs * go_vfs_new(uintptr_t handle) {
+    go_vfs *v;
+
+    v = (go_vfs *)malloc(sizeof(go_vfs));
+    if (v == 0) {
+        return 0;
+    }
+    v->base = go_vfs_methods;
+    v->handle = handle;
+
+    return (unqlite_vfs *)v;
+}
+
+/*
+Encrypting VFS of a database, wraps the VFS of the library. The content of the
+files is transformed by the Go codec, locking and syncing go to the wrapped file.
+*/
+typedef struct codec_vfs codec_vfs;
+struct codec_vfs {
+    unqlite_vfs base;
+    const unqlite_vfs *pReal;
+    uintptr_t handle;
+};
+
+typedef struct codec_file codec_file;
+struct codec_file {
+    const unqlite_io_methods *pMethods;
+    uintptr_t handle;
+    unqlite_file *pReal;
+    int iLock;  /* Lock level the engine holds */
+};
+
+/* The wrapped file follows the codec file, keep it aligned */
+#define CODEC_FILE_SIZE ((sizeof(codec_file) + 15) & ~(size_t)15)
+
+static int codec_close(unqlite_file *pFile) {
+    codec_file *p = (codec_file *)pFile;
+    int rc;
+
+    rc = goCodecClose(p->handle);
+    p->pReal->pMethods->xClose(p->pReal);
+
+    return rc;
+}
+
+static int codec_read(unqlite_file *pFile, void *pBuf, unqlite_int64 iAmt, unqlite_int64 iOfst) {
+    return goCodecRead(((codec_file *)pFile)->handle, pBuf, iAmt, iOfst);
+}
+
+static int codec_write(unqlite_file *pFile, const void *pBuf, unqlite_int64 iAmt, unqlite_int64 iOfst) {
+    return goCodecWrite(((codec_file *)pFile)->handle, (void *)pBuf, iAmt, iOfst);
+}
+
+static int codec_truncate(unqlite_file *pFile, unqlite_int64 size) {
+    return goCodecTruncate(((codec_file *)pFile)->handle, size);
+}
+
+static int codec_file_size(unqlite_file *pFile, unqlite_int64 *pSize) {
+    return goCodecFileSize(((codec_file *)pFile)->handle, pSize);
+}
+
+static int codec_sync(unqlite_file *pFile, int flags) {
+    unqlite_file *pReal = ((codec_file *)pFile)->pReal;
+    return pReal->pMethods->xSync(pReal, flags);
+}
+
+static int codec_lock(unqlite_file *pFile, int level) {
+    codec_file *p = (codec_file *)pFile;
+    int rc;
+
+    rc = p->pReal->pMethods->xLock(p->pReal, level);
+    if (rc == UNQLITE_OK && level > p->iLock) {
+        p->iLock = level;
+    }
+
+    return rc;
+}
+
+static int codec_unlock(unqlite_file *pFile, int level) {
+    codec_file *p = (codec_file *)pFile;
+    int rc;
+
+    rc = p->pReal->pMethods->xUnlock(p->pReal, level);
+    if (rc == UNQLITE_OK && level < p->iLock) {
+        p->iLock = level;
+    }
+
+    return rc;
+}
+
+static int codec_check_reserved_lock(unqlite_file *pFile, int *pResOut) {
+    unqlite_file *pReal = ((codec_file *)pFile)->pReal;
+    return pReal->pMethods->xCheckReservedLock(pReal, pResOut);
+}
+
+static int codec_sector_size(unqlite_file *pFile) {
+    unqlite_file *pReal = ((codec_file *)pFile)->pReal;
+
+    if (pReal->pMethods->xSectorSize == 0) {
+        return 512;
+    }
+
+    return pReal->pMethods->xSectorSize(pReal);
+}
+
+static const unqlite_io_methods codec_methods = {
+    1,
+    codec_close,
+    codec_read,
+    codec_write,
+    codec_truncate,
+    codec_sync,
+    codec_file_size,
+    codec_lock,
+    codec_unlock,
+    codec_check_reserved_lock,
+    codec_sector_size,
+};
+
+static int codec_open(unqlite_vfs *pVfs, const char *zName, unqlite_file *pFile, unsigned int flags) {
+    codec_vfs *v = (codec_vfs *)pVfs;
+    codec_file *p = (codec_file *)pFile;
+    unqlite_file *pReal = (unqlite_file *)((char *)pFile + CODEC_FILE_SIZE);
+    uintptr_t handle = 0;
+    int rc;
+
+    rc = v->pReal->xOpen((unqlite_vfs *)v->pReal, zName, pReal, flags);
+    if (rc != UNQLITE_OK) {
+        return rc;
+    }
+
+    rc = goCodecOpen(v->handle, pReal, flags, &handle);
+    if (rc != UNQLITE_OK) {
+        pReal->pMethods->xClose(pReal);
+        return rc;
+    }
+    p->handle = handle;
+    p->pReal = pReal;
+    p->iLock = UNQLITE_LOCK_NONE;
+    p->pMethods = &codec_methods;
+
+    return UNQLITE_OK;
+}
+
+static int codec_delete(unqlite_vfs *pVfs, const char *zName, int syncDir) {
+    const unqlite_vfs *pReal = ((codec_vfs *)pVfs)->pReal;
+    return pReal->xDelete((unqlite_vfs *)pReal, zName, syncDir);
+}
+
+static int codec_access(unqlite_vfs *pVfs, const char *zName, int flags, int *pResOut) {
+    const unqlite_vfs *pReal = ((codec_vfs *)pVfs)->pReal;
+    return pReal->xAccess((unqlite_vfs *)pReal, zName, flags, pResOut);
+}
+
+static int codec_full_pathname(unqlite_vfs *pVfs, const char *zName, int buf_len, char *zBuf) {
+    const unqlite_vfs *pReal = ((codec_vfs *)pVfs)->pReal;
+    return pReal->xFullPathname((unqlite_vfs *)pReal, zName, buf_len, zBuf);
+}
+
+static int codec_current_time(unqlite_vfs *pVfs, Sytm *pOut) {
+    const unqlite_vfs *pReal = ((codec_vfs *)pVfs)->pReal;
+    return pReal->xCurrentTime((unqlite_vfs *)pReal, pOut);
+}
+
+unqlite_vfs * codec_vfs_new(uintptr_t handle, const unqlite_vfs *pReal) {
+    codec_vfs *v;
+
+    if (pReal == 0) {
+        pReal = lib_vfs;
+    }
+
+    if (pReal == 0) {
+        return 0;
+    }
+    v = (codec_vfs *)calloc(1, sizeof(codec_vfs));
+    if (v == 0) {
+        return 0;
+    }
+
+    /* Methods not wrapped below do not receive the VFS */
+    v->base = *pReal;
+    v->base.zName = "codec";
+    v->base.szOsFile = (int)(CODEC_FILE_SIZE + sizeof(unqlite_file)) + pReal->szOsFile;
+    v->base.xOpen = codec_open;
+    v->base.xDelete = codec_delete;
+    v->base.xAccess = codec_access;
+    if (pReal->xFullPathname) {
+        v->base.xFullPathname = codec_full_pathname;
+    }
+    if (pReal->xCurrentTime) {
+        v->base.xCurrentTime = codec_current_time;
+    }
+    v->pReal = pReal;
+    v->handle = handle;
+
+    return (unqlite_vfs *)v;
+}
+
+/*
+Routing VFS, the VFS of the library. The engine takes a single VFS for all the
+databases, so the file name of a database opened with its own VFS carries the
+address of that VFS: "\x01<hex address>\x01<file name>". The name of its journal
+carries the same route. Other files go to the Go VFS of the library.
+*/
+#define ROUTE_MARK '\x01'
+
+/* Large enough for the files of every routed VFS, a codec file wraps a Go file */
+#define ROUTE_FILE_SIZE ((int)(CODEC_FILE_SIZE + sizeof(unqlite_file) + sizeof(go_file)))
+
+/* route returns the VFS of the file zName, its name without the route and the length of the route */
+static unqlite_vfs * route(const char *zName, const char **pzPath, int *pnRoute) {
+    char *zEnd;
+    unqlite_vfs *pVfs;
+
+    if (zName == 0 || zName[0] != ROUTE_MARK) {
+        *pzPath = zName;
+        *pnRoute = 0;
+        return lib_vfs;
+    }
+    pVfs = (unqlite_vfs *)(uintptr_t)strtoull(&zName[1], &zEnd, 16);
+    *pzPath = &zEnd[1];
+    *pnRoute = (int)(*pzPath - zName);
+
+    return pVfs;
+}
+
+static int route_open(unqlite_vfs *pVfs, const char *zName, unqlite_file *pFile, unsigned int flags) {
+    const char *zPath;
+    int n;
+
+    pVfs = route(zName, &zPath, &n);
+    return pVfs->xOpen(pVfs, zPath, pFile, flags);
+}
+
+static int route_delete(unqlite_vfs *pVfs, const char *zName, int syncDir) {
+    const char *zPath;
+    int n;
+
+    pVfs = route(zName, &zPath, &n);
+    return pVfs->xDelete(pVfs, zPath, syncDir);
+}
+
+static int route_access(unqlite_vfs *pVfs, const char *zName, int flags, int *pResOut) {
+    const char *zPath;
+    int n;
+
+    pVfs = route(zName, &zPath, &n);
+    return pVfs->xAccess(pVfs, zPath, flags, pResOut);
+}
+
+static int route_full_pathname(unqlite_vfs *pVfs, const char *zName, int buf_len, char *zBuf) {
+    const char *zPath;
+    int n;
+
+    pVfs = route(zName, &zPath, &n);
+    if (pVfs->xFullPathname == 0) {
+        return UNQLITE_NOTIMPLEMENTED;
+    }
+    if (n >= buf_len) {
+        return UNQLITE_FULL;
+    }
+
+    /* Keep the route, the journal name is derived from the full path */
+    memcpy(zBuf, zName, n);
+    return pVfs->xFullPathname(pVfs, zPath, buf_len - n, &zBuf[n]);
+}
+
+static int route_tmp_dir(unqlite_vfs *pVfs, char *zBuf, int buf_len) {
+    return lib_vfs->xTmpDir(lib_vfs, zBuf, buf_len);
+}
+
+static int route_sleep(unqlite_vfs *pVfs, int microseconds) {
+    return lib_vfs->xSleep(lib_vfs, microseconds);
+}
+
+static int route_current_time(unqlite_vfs *pVfs, Sytm *pOut) {
+    return lib_vfs->xCurrentTime(lib_vfs, pOut);
+}
+
+static const unqlite_vfs route_vfs = {
+    "route",
+    1,
+    ROUTE_FILE_SIZE,
+    512,
+    route_open,
+    route_delete,
+    route_access,
+    route_full_pathname,
+    route_tmp_dir,
+    route_sleep,
+    route_current_time,
+    0,
+};
+
+int lib_config_vfs(uintptr_t handle) {
+    unqlite_vfs *v;
+    int rc;
+
+    v = go_vfs_new(handle);
+    if (v == 0) {
+        return UNQLITE_NOMEM;
+    }
+
+    /* Fails with UNQLITE_LOCKED once the library is initialized */
+    rc = unqlite_lib_config(UNQLITE_LIB_CONFIG_VFS, &route_vfs);
+    if (rc != UNQLITE_OK) {
+        free(v);
+        return rc;
+    }
+
+    /* No database is open, the previous VFS is unused */
+    free(lib_vfs);
+    lib_vfs = v;
+
+    return UNQLITE_OK;
+}
+
+int codec_real_read(unqlite_file *pReal, void *pBuf, unqlite_int64 iAmt, unqlite_int64 iOfst) {
+    return pReal->pMethods->xRead(pReal, pBuf, iAmt, iOfst);
+}
+
+int codec_real_write(unqlite_file *pReal, const void *pBuf, unqlite_int64 iAmt, unqlite_int64 iOfst) {
+    return pReal->pMethods->xWrite(pReal, pBuf, iAmt, iOfst);
+}
+
+int codec_real_truncate(unqlite_file *pReal, unqlite_int64 size) {
+    return pReal->pMethods->xTruncate(pReal, size);
+}
+
+int codec_real_sync(unqlite_file *pReal) {
+    return pReal->pMethods->xSync(pReal, UNQLITE_SYNC_NORMAL);
+}
+
+int codec_real_size(unqlite_file *pReal, unqlite_int64 *pSize) {
+    return pReal->pMethods->xFileSize(pReal, pSize);
+}
+
+int codec_real_lock(unqlite_file *pReal, int level) {
+    return pReal->pMethods->xLock(pReal, level);
+}
+
+int codec_real_unlock(unqlite_file *pReal, int level) {
+    return pReal->pMethods->xUnlock(pReal, level);
+}
+
+int codec_lock_level(unqlite_file *pReal) {
+    /* The wrapped file follows the codec file, see codec_open */
+    return ((codec_file *)((char *)pReal - CODEC_FILE_SIZE))->iLock;
+}
+
+/*
+Go Key/Value storage engine. The methods carry the handle of the Go constructor,
+engines and cursors the handle of their Go instance.
+*/
+typedef struct go_kv_methods go_kv_methods;
+struct go_kv_methods {
+    unqlite_kv_methods base;
+    uintptr_t handle;
+};
+
+typedef struct go_kv_engine go_kv_engine;
+struct go_kv_engine {
+    const unqlite_kv_io *pIo;
+    uintptr_t handle;
+};
+
+typedef struct go_kv_cursor go_kv_cursor;
+struct go_kv_cursor {
+    unqlite_kv_engine *pStore;
+    uintptr_t handle;
+};
+
+#define KV_HANDLE(pEngine) (((go_kv_engine *)(pEngine))->handle)
+#define KV_CURSOR_HANDLE(pCursor) (((go_kv_cursor *)(pCursor))->handle)
+
+static int kv_init(unqlite_kv_engine *pEngine, int iPageSize) {
+    uintptr_t handle = ((go_kv_methods *)pEngine->pIo->pMethods)->handle;
+
+    return goKVInit(handle, pEngine, iPageSize, &KV_HANDLE(pEngine));
+}
+
+static void kv_release(unqlite_kv_engine *pEngine) {
+    goKVRelease(KV_HANDLE(pEngine));
+}
+
+static int kv_open(unqlite_kv_engine *pEngine, pgno nPage) {
+    return goKVOpen(KV_HANDLE(pEngine), pEngine, (unqlite_int64)nPage);
+}
+
+static int kv_replace(unqlite_kv_engine *pEngine, const void *pKey, int nKeyLen, const void *pData, unqlite_int64 nDataLen) {
+    return goKVReplace(KV_HANDLE(pEngine), pEngine, (void *)pKey, nKeyLen, (void *)pData, nDataLen, 0);
+}
+
+static int kv_append(unqlite_kv_engine *pEngine, const void *pKey, int nKeyLen, const void *pData, unqlite_int64 nDataLen) {
+    return goKVReplace(KV_HANDLE(pEngine), pEngine, (void *)pKey, nKeyLen, (void *)pData, nDataLen, 1);
+}
+
+static void kv_cursor_init(unqlite_kv_cursor *pCursor) {
+    KV_CURSOR_HANDLE(pCursor) = goKVCursorInit(KV_HANDLE(pCursor->pStore));
+}
+
+static int kv_cursor_seek(unqlite_kv_cursor *pCursor, const void *pKey, int nByte, int iPos) {
+    return goKVCursorSeek(KV_CURSOR_HANDLE(pCursor), pCursor->pStore, (void *)pKey, nByte, iPos);
+}
+
+static int kv_cursor_first(unqlite_kv_cursor *pCursor) {
+    return goKVCursorMove(KV_CURSOR_HANDLE(pCursor), pCursor->pStore, KV_MOVE_FIRST);
+}
+
+static int kv_cursor_last(unqlite_kv_cursor *pCursor) {
+    return goKVCursorMove(KV_CURSOR_HANDLE(pCursor), pCursor->pStore, KV_MOVE_LAST);
+}
+
+static int kv_cursor_valid(unqlite_kv_cursor *pCursor) {
+    return goKVCursorValid(KV_CURSOR_HANDLE(pCursor));
+}
+
+static int kv_cursor_next(unqlite_kv_cursor *pCursor) {
+    return goKVCursorMove(KV_CURSOR_HANDLE(pCursor), pCursor->pStore, KV_MOVE_NEXT);
+}
+
+static int kv_cursor_prev(unqlite_kv_cursor *pCursor) {
+    return goKVCursorMove(KV_CURSOR_HANDLE(pCursor), pCursor->pStore, KV_MOVE_PREV);
+}
+
+static int kv_cursor_delete(unqlite_kv_cursor *pCursor) {
+    return goKVCursorMove(KV_CURSOR_HANDLE(pCursor), pCursor->pStore, KV_MOVE_DELETE);
+}
+
+static int kv_cursor_key_length(unqlite_kv_cursor *pCursor, int *pLen) {
+    unqlite_int64 n = 0;
+    int rc;
+
+    rc = goKVCursorLength(KV_CURSOR_HANDLE(pCursor), pCursor->pStore, 0, &n);
+    *pLen = (int)n;
+
+    return rc;
+}
+
+static int kv_cursor_key(unqlite_kv_cursor *pCursor, int (*xConsumer)(const void *, unsigned int, void *), void *pUserData) {
+    return goKVCursorConsume(KV_CURSOR_HANDLE(pCursor), pCursor->pStore, 0, xConsumer, (uintptr_t)pUserData);
+}
+
+static int kv_cursor_data_length(unqlite_kv_cursor *pCursor, unqlite_int64 *pLen) {
+    return goKVCursorLength(KV_CURSOR_HANDLE(pCursor), pCursor->pStore, 1, pLen);
+}
+
+static int kv_cursor_data(unqlite_kv_cursor *pCursor, int (*xConsumer)(const void *, unsigned int, void *), void *pUserData) {
+    return goKVCursorConsume(KV_CURSOR_HANDLE(pCursor), pCursor->pStore, 1, xConsumer, (uintptr_t)pUserData);
+}
+
+static void kv_cursor_reset(unqlite_kv_cursor *pCursor) {
+    goKVCursorReset(KV_CURSOR_HANDLE(pCursor));
+}
+
+static void kv_cursor_release(unqlite_kv_cursor *pCursor) {
+    goKVCursorRelease(KV_CURSOR_HANDLE(pCursor));
+}
+
+static const unqlite_kv_methods go_kv_methods_template = {
+    0,
+    sizeof(go_kv_engine),
+    sizeof(go_kv_cursor),
+    1,
+    kv_init,
+    kv_release,
+    0,
+    kv_open,
+    kv_replace,
+    kv_append,
+    kv_cursor_init,
+    kv_cursor_seek,
+    kv_cursor_first,
+    kv_cursor_last,
+    kv_cursor_valid,
+    kv_cursor_next,
+    kv_cursor_prev,
+    kv_cursor_delete,
+    kv_cursor_key_length,
+    kv_cursor_key,
+    kv_cursor_data_length,
+    kv_cursor_data,
+    kv_cursor_reset,
+    kv_cursor_release,
+};
+
+unqlite_kv_methods * go_kv_methods_new(const char *zName, uintptr_t handle) {
+    go_kv_methods *m;
+
+    m = (go_kv_methods *)malloc(sizeof(go_kv_methods) + strlen(zName) + 1);
+    if (m == 0) {
+        return 0;
+    }
+    m->base = go_kv_methods_template;
+    m->base.zName = strcpy((char *)&m[1], zName);
+    m->handle = handle;
+
+    return (unqlite_kv_methods *)m;
+}
+
+int lib_config_kv_engine(unqlite_kv_methods *pMethods) {
+    return unqlite_lib_config(UNQLITE_LIB_CONFIG_STORAGE_ENGINE, pMethods);
+}
+
+void kv_engine_err(unqlite_kv_engine *pEngine, const char *zErr) {
+    pEngine->pIo->xErr(pEngine->pIo->pHandle, zErr);
+}
+
+int kv_consume(kv_consumer xConsumer, const void *pData, unsigned int nLen, uintptr_t pUserData) {
+    return xConsumer(pData, nLen, (void *)pUserData);
+}
+
+uintptr_t kv_cursor_engine(unqlite_kv_cursor *pCursor) {
+    if (pCursor->pStore->pIo->pMethods->xInit != kv_init) {
+        /* Built-in engine */
+        return 0;
+    }
+
+    return KV_HANDLE(pCursor->pStore);
+}
+
+int lib_config_page_size(int iPageSize) {
+    return unqlite_lib_config(UNQLITE_LIB_CONFIG_PAGE_SIZE, iPageSize);
+}
+
+int lib_config_thread_level(int single) {
+    if (single) {
+        return unqlite_lib_config(UNQLITE_LIB_CONFIG_THREAD_LEVEL_SINGLE);
+    }
+
+    return unqlite_lib_config(UNQLITE_LIB_CONFIG_THREAD_LEVEL_MULTI);
+}
+
+/* SXERR_RETRY of the engine, the failed allocation is retried */
+#define MEM_ERR_RETRY (-33)
+
+static int lib_mem_err(void *pUserData) {
+    if (goMemError((uintptr_t)pUserData)) {
+        return MEM_ERR_RETRY;
+    }
+
+    return UNQLITE_ABORT;
+}
+
+int lib_config_mem_err(uintptr_t handle) {
+    if (handle == 0) {
+        return unqlite_lib_config(UNQLITE_LIB_CONFIG_MEM_ERR_CALLBACK, (int (*)(void *))0, (void *)0);
+    }
+
+    return unqlite_lib_config(UNQLITE_LIB_CONFIG_MEM_ERR_CALLBACK, lib_mem_err, (void *)handle);
+}
+
 #ifdef __cplusplus
 extern  }
 #endif
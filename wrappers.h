@@ -1,7 +1,108 @@
 #include <unqlite.h>
+#include <stdint.h>
 
-char * extract_unqlite_log_error(unqlite *pDb, char *buffer);
+int config_max_page_cache(unqlite *pDb, int nMaxPage);
 
-char * extract_vm_output(unqlite_vm *pvm, int *length);
+int config_kv_engine(unqlite *pDb, const char *zKvName);
+
+int config_disable_auto_commit(unqlite *pDb);
+
+int config_get_kv_name(unqlite *pDb, const char **pzPtr);
+
+int config_err_log(unqlite *pDb, const char **pzBuf, int *pLen);
+
+int config_err_log_reset(unqlite *pDb);
+
+int config_jx9_err_log(unqlite *pDb, const char **pzBuf, int *pLen);
+
+#define KV_SLOTS 16
+
+int kv_config_cmp_func(unqlite *pDb, int slot);
+
+int kv_config_hash_func(unqlite *pDb, int slot);
+
+int kv_fetch_to(unqlite *pDb, const void *pKey, int nKeyLen, uintptr_t handle);
+
+int kv_cursor_key_to(unqlite_kv_cursor *pCursor, uintptr_t handle);
+
+int kv_cursor_data_to(unqlite_kv_cursor *pCursor, uintptr_t handle);
+
+int vm_create_function(unqlite_vm *pVm, const char *zName, uintptr_t handle);
+
+int vm_create_var(unqlite_vm *pVm, const char *zName, unqlite_value *pValue);
+
+int value_walk(unqlite_value *pArray, uintptr_t handle);
+
+const char * extract_vm_output(unqlite_vm *pvm, int *length);
+
+int vm_config_output(unqlite_vm *pVm, uintptr_t handle);
+
+int vm_config_import_path(unqlite_vm *pVm, const char *zPath);
+
+int vm_config_fs_stream(unqlite_vm *pVm);
+
+int vm_exec(unqlite_vm *pVm, uintptr_t fs);
+
+int vm_config_err_report(unqlite_vm *pVm, uintptr_t handle);
+
+int vm_config_argv(unqlite_vm *pVm, const char *zValue);
+
+unqlite_value * vm_exec_value(unqlite_vm *pVm);
+
+int vm_create_constant(unqlite_vm *pVm, const char *zName, uintptr_t handle);
+
+int vm_dump_to(unqlite_vm *pVm, uintptr_t handle);
+
+int vm_config_http_request(unqlite_vm *pVm, const char *zRequest, int nByte);
+
+int vm_config_server_attr(unqlite_vm *pVm, const char *zKey, const char *zValue, int nLen);
+
+int vm_config_env_attr(unqlite_vm *pVm, const char *zKey, const char *zValue, int nLen);
 
 char * extract_variable_as_string(unqlite_value *unqlite_value, int *len);
+
+int lib_config_vfs(uintptr_t handle);
+
+unqlite_vfs * go_vfs_new(uintptr_t handle);
+
+unqlite_vfs * codec_vfs_new(uintptr_t handle, const unqlite_vfs *pReal);
+
+int codec_real_read(unqlite_file *pReal, void *pBuf, unqlite_int64 iAmt, unqlite_int64 iOfst);
+
+int codec_real_write(unqlite_file *pReal, const void *pBuf, unqlite_int64 iAmt, unqlite_int64 iOfst);
+
+int codec_real_truncate(unqlite_file *pReal, unqlite_int64 size);
+
+int codec_real_sync(unqlite_file *pReal);
+
+int codec_real_size(unqlite_file *pReal, unqlite_int64 *pSize);
+
+int codec_real_lock(unqlite_file *pReal, int level);
+
+int codec_real_unlock(unqlite_file *pReal, int level);
+
+int codec_lock_level(unqlite_file *pReal);
+
+#define KV_MOVE_FIRST 0
+#define KV_MOVE_LAST 1
+#define KV_MOVE_NEXT 2
+#define KV_MOVE_PREV 3
+#define KV_MOVE_DELETE 4
+
+typedef int (*kv_consumer)(const void *, unsigned int, void *);
+
+unqlite_kv_methods * go_kv_methods_new(const char *zName, uintptr_t handle);
+
+int lib_config_kv_engine(unqlite_kv_methods *pMethods);
+
+void kv_engine_err(unqlite_kv_engine *pEngine, const char *zErr);
+
+int kv_consume(kv_consumer xConsumer, const void *pData, unsigned int nLen, uintptr_t pUserData);
+
+uintptr_t kv_cursor_engine(unqlite_kv_cursor *pCursor);
+
+int lib_config_page_size(int iPageSize);
+
+int lib_config_thread_level(int single);
+
+int lib_config_mem_err(uintptr_t handle);